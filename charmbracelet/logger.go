@@ -127,3 +127,9 @@ func (ml *MultiLogger) Printf(format string, a ...any) {
 		l.Print(fmt.Sprintf(format, a...))
 	}
 }
+
+func (ml *MultiLogger) Log(level log.Level, msg any, kvs ...any) {
+	for _, l := range ml.loggers {
+		l.Log(level, msg, kvs...)
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package crypto
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"hash"
+)
+
+// PBKDF2 derives a key of keyLen bytes from password and salt as described in
+// RFC 8018, using HMAC with the given hash function as the PRF.
+func PBKDF2(password, salt []byte, iter, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var index [4]byte
+
+	derived := make([]byte, 0, numBlocks*hashLen)
+	block := make([]byte, hashLen)
+
+	for i := 1; i <= numBlocks; i++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(index[:], uint32(i)) //nolint:gosec // block count is bounded by keyLen
+		prf.Write(index[:])
+
+		derived = prf.Sum(derived)
+		sum := derived[len(derived)-hashLen:]
+		copy(block, sum)
+
+		for range iter - 1 {
+			prf.Reset()
+			prf.Write(block)
+			block = prf.Sum(block[:0])
+
+			for j := range block {
+				sum[j] ^= block[j]
+			}
+		}
+	}
+
+	return derived[:keyLen]
+}
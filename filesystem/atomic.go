@@ -0,0 +1,134 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package filesystem
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// WriteFileAtomic is WriteFile that leaves either the old or the new contents
+// in place if it is interrupted, see AtomicWriter.
+func WriteFileAtomic(fileName string, data []byte, perm fs.FileMode) error {
+	w, err := NewAtomicWriter(fileName, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return errors.Join(err, w.Abort())
+	}
+
+	return w.Close()
+}
+
+// AtomicWriter writes to a temporary file next to the destination, which
+// Close syncs and renames into place, and Abort removes. An existing file
+// keeps its permissions, otherwise perm is used. A symlink is followed, so
+// the file it points to is replaced and the link kept, unless that file does
+// not exist, in which case the link itself is replaced.
+type AtomicWriter struct {
+	file     *os.File
+	fileName string
+	closed   bool
+}
+
+func NewAtomicWriter(fileName string, perm fs.FileMode) (*AtomicWriter, error) {
+	if resolved, err := filepath.EvalSymlinks(fileName); err == nil {
+		fileName = resolved
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if info, err := os.Stat(fileName); err == nil {
+		perm = info.Mode().Perm()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := file.Chmod(perm); err != nil && runtime.GOOS != "windows" {
+		return nil, errors.Join(err, file.Close(), os.Remove(file.Name()))
+	}
+
+	return &AtomicWriter{file: file, fileName: fileName, closed: false}, nil
+}
+
+func (w *AtomicWriter) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+// Close replaces the destination with what was written. The write is
+// discarded if any step fails.
+func (w *AtomicWriter) Close() error {
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	w.closed = true
+
+	if err := w.file.Sync(); err != nil {
+		return errors.Join(err, w.file.Close(), os.Remove(w.file.Name()))
+	}
+
+	if err := w.file.Close(); err != nil {
+		return errors.Join(err, os.Remove(w.file.Name()))
+	}
+
+	if err := os.Rename(w.file.Name(), w.fileName); err != nil {
+		return errors.Join(err, os.Remove(w.file.Name()))
+	}
+
+	return syncDir(filepath.Dir(w.fileName))
+}
+
+// Abort discards the write and leaves the destination untouched. It does
+// nothing after Close.
+func (w *AtomicWriter) Abort() error {
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+
+	return errors.Join(w.file.Close(), os.Remove(w.file.Name()))
+}
+
+// syncDir persists a rename in dir. Windows cannot sync directories and
+// makes renames durable by itself.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
@@ -0,0 +1,105 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package filesystem_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/ricochhet/minicommon/filesystem"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "config.json")
+
+	if err := os.WriteFile(name, []byte("old"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := filesystem.WriteFileAtomic(name, []byte("new"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(name); string(data) != "new" {
+		t.Fatalf("unexpected contents %q", data)
+	}
+
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0o640 {
+		t.Fatalf("permissions were not kept: %v", info.Mode().Perm())
+	}
+
+	w, err := filesystem.NewAtomicWriter(name, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Write([]byte("discarded")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(name); string(data) != "new" {
+		t.Fatalf("the aborted write replaced the file: %q", data)
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("temporary files were left behind: %v", entries)
+	}
+}
+
+func TestWriteFileAtomicSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks needs privileges")
+	}
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.json")
+	link := filepath.Join(dir, "link.json")
+
+	if err := os.WriteFile(target, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("target.json", link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := filesystem.WriteFileAtomic(link, []byte("new"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("the link was replaced: %v", err)
+	}
+
+	if data, _ := os.ReadFile(target); string(data) != "new" {
+		t.Fatalf("the target was not written: %q", data)
+	}
+}
@@ -35,6 +35,7 @@ import (
 )
 
 var (
+	ErrUnsafePath  = errors.New("path escapes the base directory")
 	errNoNameFound = errors.New("name could not be found in file path")
 	errNoPathFound = errors.New("file path could not be found in file name")
 	errFileExists  = errors.New("file exists in destination path")
@@ -62,6 +63,20 @@ func FromCwd(path1 ...string) (string, error) {
 	return filepath.Join(path...), nil
 }
 
+// SecureJoin joins name to base like Combine, but fails with ErrUnsafePath
+// when the result would lie outside of base, as archive entries named
+// "../x" would.
+func SecureJoin(base, name string) (string, error) {
+	path := filepath.Join(base, name)
+
+	rel, err := filepath.Rel(base, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+
+	return path, nil
+}
+
 func GetDirectoryName(fileName string) string {
 	return filepath.Dir(fileName)
 }
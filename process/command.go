@@ -0,0 +1,274 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// orphanWaitDelay is how long Run waits for the output of a killed process
+// to be closed by the children it leaves behind.
+const orphanWaitDelay = time.Second
+
+// Command describes a process for Run. Env holds "KEY=value" entries that
+// override the current environment, or replace it with ClearEnv. With
+// Capture, up to CaptureLimit bytes of each stream are kept in the Result,
+// or all of it when the limit is zero, in addition to being written to
+// Stdout and Stderr. OnStdoutLine and OnStderrLine receive each line of
+// output without its line ending, and TeeFile names a file both streams are
+// appended to. A zero Timeout only stops the process with ctx. With a
+// Timeout, KillGrace or KillOnParentExit, the process is started in its own
+// process group on unix and stopping it stops the whole tree, which gets
+// KillGrace to exit before it is killed, see KillTree; signals sent to the
+// terminal then no longer reach it. Otherwise only the process itself is
+// killed. KillOnParentExit kills the process if this one dies first, on
+// Linux only. A non-zero SampleInterval records the resident memory of the
+// process at that interval while it runs, on Linux only.
+type Command struct {
+	Name               string
+	Args               []string
+	Dir                string
+	Env                []string
+	ClearEnv           bool
+	Stdin              io.Reader
+	Stdout             io.Writer
+	Stderr             io.Writer
+	OnStdoutLine       func(string)
+	OnStderrLine       func(string)
+	TeeFile            string
+	Capture            bool
+	CaptureLimit       int
+	Timeout            time.Duration
+	KillGrace          time.Duration
+	KillOnParentExit   bool
+	HideWindow         bool
+	RelativeExecutable bool
+	SampleInterval     time.Duration
+}
+
+// Result describes a finished process. ExitCode is -1 when the process was
+// killed or could not be started. Duration is the wall time. The CPU times
+// are those of the process and the children it waited for, and so are
+// MaxRSS and the bytes read from and written to storage, which are only
+// reported on unix other than macOS, where only operations are counted.
+type Result struct {
+	ExitCode   int
+	Stdout     []byte
+	Stderr     []byte
+	Duration   time.Duration
+	UserTime   time.Duration
+	SystemTime time.Duration
+	MaxRSS     uint64
+	ReadBytes  uint64
+	WriteBytes uint64
+	Samples    []RSSSample
+}
+
+// Run starts the command and waits for it. The process and any children it
+// started are killed once ctx is done or the timeout passes, in which case
+// the context's error is returned. A non-zero exit status is returned as an
+// *exec.ExitError alongside the Result.
+func Run(ctx context.Context, c Command) (Result, error) {
+	//nolint:exhaustruct // filled in once the process exits
+	result := Result{ExitCode: -1}
+
+	path, err := c.path()
+	if err != nil {
+		return result, err
+	}
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, path, c.Args...)
+
+	cmd.Dir = c.Dir
+	cmd.Env = c.env()
+	cmd.Stdin = c.Stdin
+
+	stdout, stderr := c.Stdout, c.Stderr
+	if stdout != nil && interfaceEqual(stdout, stderr) {
+		// exec only shares one pipe between identical writers, which the
+		// writers added below would hide, so serialize the writes instead.
+		stdout = &syncWriter{w: stdout, mu: sync.Mutex{}}
+		stderr = stdout
+	}
+
+	if c.TeeFile != "" {
+		tee, err := os.OpenFile(c.TeeFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return result, err
+		}
+		defer tee.Close()
+
+		shared := &syncWriter{w: tee, mu: sync.Mutex{}}
+		stdout, stderr = combine(stdout, shared), combine(stderr, shared)
+	}
+
+	var captureOut, captureErr *limitedBuffer
+
+	if c.Capture {
+		captureOut = &limitedBuffer{buf: bytes.Buffer{}, limit: c.CaptureLimit}
+		captureErr = &limitedBuffer{buf: bytes.Buffer{}, limit: c.CaptureLimit}
+		stdout, stderr = combine(stdout, captureOut), combine(stderr, captureErr)
+	}
+
+	if c.OnStdoutLine != nil {
+		lines := NewLineWriter(c.OnStdoutLine)
+		defer lines.Flush()
+
+		stdout = combine(stdout, lines)
+	}
+
+	if c.OnStderrLine != nil {
+		lines := NewLineWriter(c.OnStderrLine)
+		defer lines.Flush()
+
+		stderr = combine(stderr, lines)
+	}
+
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	if runtime.GOOS == "windows" {
+		setHideWindowAttr(cmd, c.HideWindow)
+	}
+
+	if c.Timeout > 0 || c.KillGrace > 0 || c.KillOnParentExit {
+		setKillTree(cmd, c.KillGrace, c.KillOnParentExit)
+	} else if ctx.Done() != nil {
+		// Children that outlive the killed process may hold its output open.
+		cmd.WaitDelay = orphanWaitDelay
+	}
+
+	start := time.Now()
+
+	if err := startCommand(cmd); err != nil {
+		return result, err
+	}
+
+	sampler := startSampler(cmd.Process.Pid, start, c.SampleInterval)
+	err = cmd.Wait()
+	result.Duration = time.Since(start)
+	result.Samples = sampler.stop()
+
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+		setUsage(&result, cmd.ProcessState)
+	}
+
+	if c.Capture {
+		result.Stdout = captureOut.buf.Bytes()
+		result.Stderr = captureErr.buf.Bytes()
+	}
+
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return result, ctxErr
+	}
+
+	return result, err
+}
+
+func (c Command) path() (string, error) {
+	if !c.RelativeExecutable {
+		return c.Name, nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(exe), c.Name), nil
+}
+
+// env returns nil to inherit the environment unchanged. exec keeps the last
+// of duplicate keys, so appending is enough to override.
+func (c Command) env() []string {
+	if c.ClearEnv {
+		return append([]string{}, c.Env...)
+	}
+
+	if len(c.Env) == 0 {
+		return nil
+	}
+
+	return append(os.Environ(), c.Env...)
+}
+
+func combine(w, extra io.Writer) io.Writer {
+	if w == nil {
+		return extra
+	}
+
+	return io.MultiWriter(w, extra)
+}
+
+// interfaceEqual compares writers the way exec does, treating values that
+// cannot be compared as different.
+func interfaceEqual(a, b any) (equal bool) {
+	defer func() {
+		if recover() != nil {
+			equal = false
+		}
+	}()
+
+	return a == b
+}
+
+// limitedBuffer keeps the first limit bytes written to it, or everything for
+// a zero limit, and reports every write as complete.
+type limitedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	keep := p
+	if l.limit > 0 {
+		keep = p[:min(len(p), max(0, l.limit-l.buf.Len()))]
+	}
+
+	l.buf.Write(keep)
+
+	return len(p), nil
+}
+
+type syncWriter struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.w.Write(p)
+}
@@ -0,0 +1,64 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// statPGRP is the position of the process group in /proc/<pid>/stat after
+// the command name.
+const statPGRP = 2
+
+// groupExists reports whether any process of the group is still running.
+// Unlike signalling the group, it ignores zombies, which stay in their group
+// until whoever inherited them reaps them.
+func groupExists(pgid int) bool {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return true
+	}
+
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+
+		stat, err := os.ReadFile(filepath.Join(procRoot, entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+
+		end := bytes.LastIndexByte(stat, ')')
+		if end < 0 {
+			continue
+		}
+
+		fields := strings.Fields(string(stat[end+1:]))
+		if len(fields) > statPGRP && fields[statPGRP] == strconv.Itoa(pgid) && fields[statState] != "Z" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,89 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/ricochhet/minicommon/charmbracelet"
+)
+
+// LineWriter calls fn with every line written to it, without the trailing
+// "\n" or "\r\n". Flush passes on a last line that has no line ending.
+type LineWriter struct {
+	fn  func(string)
+	buf []byte
+	mu  sync.Mutex
+}
+
+func NewLineWriter(fn func(string)) *LineWriter {
+	return &LineWriter{fn: fn, buf: nil, mu: sync.Mutex{}}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		w.fn(strings.TrimSuffix(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+func (w *LineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.fn(strings.TrimSuffix(string(w.buf), "\r"))
+		w.buf = nil
+	}
+}
+
+// LogLines returns a line callback that logs each line to logger at level,
+// tagged with the process name.
+func LogLines(logger *charmbracelet.MultiLogger, level log.Level, name string) func(string) {
+	return func(line string) {
+		logger.Log(level, line, "process", name)
+	}
+}
+
+// WithLogger returns the command with its stdout and stderr lines logged to
+// logger at the given levels, tagged with the base name of the executable.
+func (c Command) WithLogger(logger *charmbracelet.MultiLogger, stdout, stderr log.Level) Command {
+	name := strings.TrimSuffix(filepath.Base(c.Name), ".exe")
+
+	c.OnStdoutLine = LogLines(logger, stdout, name)
+	c.OnStderrLine = LogLines(logger, stderr, name)
+
+	return c
+}
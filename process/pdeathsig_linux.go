@@ -0,0 +1,66 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"os/exec"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+//nolint:gochecknoglobals // wontfix
+var (
+	forkThread sync.Once
+	forks      chan func()
+)
+
+// setParentDeathSignal has the kernel kill the child when the thread that
+// started it exits. That is not only when the process exits: Go also ends
+// the thread of a goroutine that returns while locked to it, which can be one
+// a command was started from before, see startCommand.
+func setParentDeathSignal(attr *syscall.SysProcAttr) {
+	attr.Pdeathsig = syscall.SIGKILL
+}
+
+// startCommand starts cmd. A command with a parent death signal is started
+// from a thread locked to a goroutine that never returns, so that the signal
+// is only sent when this process exits.
+func startCommand(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil || cmd.SysProcAttr.Pdeathsig == 0 {
+		return cmd.Start()
+	}
+
+	forkThread.Do(func() {
+		forks = make(chan func())
+
+		go func() {
+			runtime.LockOSThread()
+
+			for fork := range forks {
+				fork()
+			}
+		}()
+	})
+
+	started := make(chan error, 1)
+	forks <- func() { started <- cmd.Start() }
+
+	return <-started
+}
@@ -0,0 +1,121 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// StageError is the failure of one stage of a pipeline.
+type StageError struct {
+	Stage int
+	Name  string
+	Err   error
+}
+
+func (e StageError) Error() string {
+	return fmt.Sprintf("pipeline stage %d (%s): %s", e.Stage, e.Name, e.Err)
+}
+
+func (e StageError) Unwrap() error {
+	return e.Err
+}
+
+// Pipeline runs the commands with the stdout of each connected to the stdin
+// of the next through an OS pipe, like a shell pipeline without the shell.
+// The first command reads its own Stdin and the last writes its own Stdout;
+// the Stdout of the others is replaced. When a stage fails, the others are
+// killed. As with pipefail, the error is a StageError for the last stage that
+// failed by itself, and the Result of every stage is returned either way.
+func Pipeline(parent context.Context, commands ...Command) ([]Result, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	results := make([]Result, len(commands))
+	failed := make([]error, len(commands))
+	stages := make([]Command, len(commands))
+	copy(stages, commands)
+
+	var pipes []*os.File
+
+	for i := range len(stages) - 1 {
+		r, w, err := os.Pipe()
+		if err != nil {
+			closeAll(pipes)
+			return results, err
+		}
+
+		stages[i].Stdout, stages[i+1].Stdin = w, r
+		pipes = append(pipes, w, r)
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+
+	for i, stage := range stages {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			result, err := Run(ctx, stage)
+
+			// Closing our copies of the pipe once a stage exits lets its
+			// neighbours see the end of input or a broken pipe.
+			if w, ok := stage.Stdout.(*os.File); ok && i < len(stages)-1 {
+				w.Close()
+			}
+
+			if r, ok := stage.Stdin.(*os.File); ok && i > 0 {
+				r.Close()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			results[i] = result
+
+			if err != nil && ctx.Err() == nil {
+				failed[i] = err
+				cancel()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	for i := len(stages) - 1; i >= 0; i-- {
+		if failed[i] != nil {
+			return results, StageError{Stage: i, Name: stages[i].Name, Err: failed[i]}
+		}
+	}
+
+	return results, parent.Err()
+}
+
+func closeAll(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
@@ -0,0 +1,228 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	procRoot = "/proc"
+	// userHZ is the unit of the times in /proc/<pid>/stat, fixed by the
+	// kernel ABI regardless of the configured tick rate.
+	userHZ = 100
+	// Positions of the fields of /proc/<pid>/stat that follow the command
+	// name.
+	statState     = 0
+	statPPID      = 1
+	statStartTime = 19
+	statRSS       = 21
+)
+
+var errMalformedStat = errors.New("malformed /proc stat file")
+
+// ProcInfo describes a running process. Name is the command name kept by
+// the kernel, which is cut to 15 bytes. Exe is the executable the process
+// was started from, even when that file has since been replaced or removed.
+// Exe and Cwd are empty when they cannot be read, usually because the
+// process belongs to another user. RSS is in bytes and State is the one
+// letter code from /proc, such as "R".
+type ProcInfo struct {
+	PID       int
+	PPID      int
+	Name      string
+	Exe       string
+	Cmdline   []string
+	Cwd       string
+	StartTime time.Time
+	RSS       uint64
+	State     string
+}
+
+// List returns every process that can be inspected, skipping those that exit
+// while being read.
+func List() ([]ProcInfo, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	boot, err := bootTime()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]ProcInfo, 0, len(entries))
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		if info, err := info(pid, boot); err == nil {
+			infos = append(infos, info)
+		}
+	}
+
+	return infos, nil
+}
+
+// FindByName returns the processes whose name, executable or first argument
+// has the given base name. Names longer than the 15 bytes the kernel keeps
+// are only found through the executable or the first argument.
+func FindByName(name string) ([]ProcInfo, error) {
+	infos, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []ProcInfo
+
+	for _, info := range infos {
+		if info.Name == name || (info.Exe != "" && filepath.Base(info.Exe) == name) ||
+			(len(info.Cmdline) > 0 && filepath.Base(info.Cmdline[0]) == name) {
+			found = append(found, info)
+		}
+	}
+
+	return found, nil
+}
+
+func Info(pid int) (ProcInfo, error) {
+	boot, err := bootTime()
+	if err != nil {
+		return ProcInfo{}, err //nolint:exhaustruct // error
+	}
+
+	return info(pid, boot)
+}
+
+func info(pid int, boot time.Time) (ProcInfo, error) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return ProcInfo{}, err //nolint:exhaustruct // error
+	}
+
+	result, err := parseStat(pid, stat, boot)
+	if err != nil {
+		return ProcInfo{}, err //nolint:exhaustruct // error
+	}
+
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil && len(cmdline) > 0 {
+		result.Cmdline = strings.Split(string(bytes.TrimRight(cmdline, "\x00")), "\x00")
+	}
+
+	// The link of a process whose executable was replaced, as by an update,
+	// points to the old file with this suffix.
+	exe, _ := os.Readlink(filepath.Join(dir, "exe"))
+	result.Exe = strings.TrimSuffix(exe, " (deleted)")
+	result.Cwd, _ = os.Readlink(filepath.Join(dir, "cwd"))
+
+	return result, nil
+}
+
+// startTime returns when pid was started.
+func startTime(pid int) (time.Time, error) {
+	boot, err := bootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	stat, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	info, err := parseStat(pid, stat, boot)
+
+	return info.StartTime, err
+}
+
+// parseStat reads /proc/<pid>/stat. The command name is enclosed in
+// parentheses and may itself contain spaces and parentheses, so the fields
+// are counted from the last closing one.
+func parseStat(pid int, stat []byte, boot time.Time) (ProcInfo, error) {
+	open, end := bytes.IndexByte(stat, '('), bytes.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return ProcInfo{}, fmt.Errorf("%w: %d", errMalformedStat, pid) //nolint:exhaustruct // error
+	}
+
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) <= statRSS {
+		return ProcInfo{}, fmt.Errorf("%w: %d", errMalformedStat, pid) //nolint:exhaustruct // error
+	}
+
+	ppid, err := strconv.Atoi(fields[statPPID])
+	if err != nil {
+		return ProcInfo{}, err //nolint:exhaustruct // error
+	}
+
+	ticks, err := strconv.ParseUint(fields[statStartTime], 10, 64)
+	if err != nil {
+		return ProcInfo{}, err //nolint:exhaustruct // error
+	}
+
+	pages, err := strconv.ParseUint(fields[statRSS], 10, 64)
+	if err != nil {
+		return ProcInfo{}, err //nolint:exhaustruct // error
+	}
+
+	return ProcInfo{
+		PID:       pid,
+		PPID:      ppid,
+		Name:      string(stat[open+1 : end]),
+		Exe:       "",
+		Cmdline:   nil,
+		Cwd:       "",
+		StartTime: boot.Add(time.Duration(ticks) * time.Second / userHZ), //nolint:gosec // uptime in ticks
+		RSS:       pages * uint64(os.Getpagesize()),                      //nolint:gosec // page size is positive
+		State:     fields[statState],
+	}, nil
+}
+
+// bootTime reads the btime line of /proc/stat.
+func bootTime() (time.Time, error) {
+	stat, err := os.ReadFile(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, line := range strings.Split(string(stat), "\n") {
+		if value, ok := strings.CutPrefix(line, "btime "); ok {
+			seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+
+			return time.Unix(seconds, 0), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%w: no btime", errMalformedStat)
+}
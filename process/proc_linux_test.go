@@ -0,0 +1,98 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/ricochhet/minicommon/process"
+)
+
+func TestInfo(t *testing.T) {
+	t.Parallel()
+
+	info, err := process.Info(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exe, _ := os.Executable()
+	cwd, _ := os.Getwd()
+
+	if info.Exe != exe || info.Cwd != cwd || info.PPID != os.Getppid() || info.RSS == 0 || info.StartTime.IsZero() {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+
+	found, err := process.FindByName(filepath.Base(exe))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range found {
+		if p.PID == os.Getpid() {
+			return
+		}
+	}
+
+	t.Fatalf("%s was not found among %+v", filepath.Base(exe), found)
+}
+
+// TestFindByNameReplaced finds a process by an executable name longer than
+// the kernel keeps after the executable was removed, as an update does.
+func TestFindByNameReplaced(t *testing.T) {
+	t.Parallel()
+
+	data, err := os.ReadFile("/bin/sleep")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "launcher-with-a-long-name")
+	if err := os.WriteFile(path, data, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(path, "30")
+	cmd.Args[0] = "launcher"
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := process.FindByName(filepath.Base(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != 1 || found[0].PID != cmd.Process.Pid || found[0].Exe != path {
+		t.Fatalf("unexpected processes: %+v", found)
+	}
+}
@@ -19,10 +19,10 @@
 package process
 
 import (
+	"context"
+	"io"
 	"os"
 	"os/exec"
-	"path/filepath"
-	"runtime"
 )
 
 func DoesFileExist(name string) bool {
@@ -34,31 +34,53 @@ func DoesFileExist(name string) bool {
 }
 
 func RunFile(name string, hideWindow, relativeExecutable, redirectStd bool, arg ...string) error {
-	path := name
-
-	if relativeExecutable {
-		cwd, err := os.Executable()
-		if err != nil {
-			return err
-		}
-
-		path = filepath.Join(filepath.Dir(cwd), name)
+	if redirectStd {
+		return RunFileWithOutput(name, hideWindow, relativeExecutable, os.Stdout, os.Stderr, arg...)
 	}
 
-	cmd := exec.Command(path, arg...)
+	return RunFileWithOutput(name, hideWindow, relativeExecutable, nil, nil, arg...)
+}
 
-	if redirectStd {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	}
+// RunFileWithOutput is RunFile with stdout and stderr sent to the given
+// writers; nil discards the stream.
+func RunFileWithOutput(name string, hideWindow, relativeExecutable bool, stdout, stderr io.Writer, arg ...string) error {
+	return RunFileWithContext(context.TODO(), name, hideWindow, relativeExecutable, stdout, stderr, arg...)
+}
 
-	if runtime.GOOS == "windows" {
-		setHideWindowAttr(cmd, hideWindow)
-	}
+// RunFileWithContext is RunFileWithOutput that kills the process once ctx is
+// done. Use Run with a KillGrace to also kill the children it started.
+//
+//nolint:lll // wontfix
+func RunFileWithContext(ctx context.Context, name string, hideWindow, relativeExecutable bool, stdout, stderr io.Writer, arg ...string) error {
+	return RunFileInDir(ctx, "", name, hideWindow, relativeExecutable, stdout, stderr, arg...)
+}
 
-	if err := cmd.Run(); err != nil {
-		return err
-	}
+// RunFileInDir is RunFileWithContext with the process started in dir; an
+// empty dir uses the current directory.
+//
+//nolint:lll // wontfix
+func RunFileInDir(ctx context.Context, dir, name string, hideWindow, relativeExecutable bool, stdout, stderr io.Writer, arg ...string) error {
+	_, err := Run(ctx, Command{
+		Name:               name,
+		Args:               arg,
+		Dir:                dir,
+		Env:                nil,
+		ClearEnv:           false,
+		Stdin:              nil,
+		Stdout:             stdout,
+		Stderr:             stderr,
+		OnStdoutLine:       nil,
+		OnStderrLine:       nil,
+		TeeFile:            "",
+		Capture:            false,
+		CaptureLimit:       0,
+		Timeout:            0,
+		KillGrace:          0,
+		KillOnParentExit:   false,
+		HideWindow:         hideWindow,
+		RelativeExecutable: relativeExecutable,
+		SampleInterval:     0,
+	})
 
-	return nil
+	return err
 }
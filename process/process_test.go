@@ -0,0 +1,448 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/ricochhet/minicommon/charmbracelet"
+	"github.com/ricochhet/minicommon/process"
+)
+
+func shell(script string) process.Command {
+	//nolint:exhaustruct // test only
+	return process.Command{Name: "/bin/sh", Args: []string{"-c", script}, Capture: true}
+}
+
+func TestRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+
+	dir := t.TempDir()
+
+	cmd := shell(`read line; echo "$line $GREETING $(pwd)"; echo oops >&2; exit 3`)
+	cmd.Dir = dir
+	cmd.Env = []string{"GREETING=hello"}
+	cmd.Stdin = strings.NewReader("input\n")
+
+	result, err := process.Run(context.Background(), cmd)
+	if err == nil || result.ExitCode != 3 {
+		t.Fatalf("expected exit code 3, got %d %v", result.ExitCode, err)
+	}
+
+	if got := string(result.Stdout); got != "input hello "+dir+"\n" || string(result.Stderr) != "oops\n" {
+		t.Fatalf("unexpected output: %q %q", got, result.Stderr)
+	}
+
+	cmd = shell("printf 0123456789")
+	cmd.CaptureLimit = 4
+
+	if result, err = process.Run(context.Background(), cmd); err != nil || string(result.Stdout) != "0123" {
+		t.Fatalf("unexpected capture: %q %v", result.Stdout, err)
+	}
+	// Without a timeout or grace period the process stays in this process
+	// group, so signals sent to the terminal reach it.
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	cmd = shell(fmt.Sprintf(`for pid in $$ %d; do read -r stat < /proc/$pid/stat; set -- ${stat##*) }; echo $3; done`, os.Getpid()))
+
+	result, err = process.Run(context.Background(), cmd)
+	if groups := strings.Fields(string(result.Stdout)); err != nil || len(groups) != 2 || groups[0] != groups[1] {
+		t.Fatalf("the process was moved to another group: %q %v", result.Stdout, err)
+	}
+}
+
+func TestSingleInstance(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	first, err := process.SingleInstanceWithArgs("app", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := process.SingleInstanceWithArgs("app", []string{"open", "file.txt"}); !errors.Is(err, process.ErrAlreadyRunning) {
+		t.Fatalf("expected the second launch to be forwarded, got %v", err)
+	}
+
+	select {
+	case args := <-first.Args:
+		if !slices.Equal(args, []string{"open", "file.txt"}) {
+			t.Fatalf("unexpected arguments: %q", args)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no arguments were forwarded")
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatalf("expected closing twice to succeed: %v", err)
+	}
+
+	next, err := process.SingleInstanceWithArgs("app", nil)
+	if err != nil {
+		t.Fatalf("expected the lock to be released: %v", err)
+	}
+
+	next.Close()
+}
+
+func TestSingleInstanceTempDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the temporary directory is per user")
+	}
+
+	tmp := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("TMPDIR", tmp)
+
+	instance, err := process.SingleInstanceWithArgs("app", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance.Close()
+
+	dir := filepath.Join(tmp, fmt.Sprintf("minicommon-%d", os.Getuid()))
+	if info, err := os.Stat(filepath.Join(dir, "app.lock")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("the lock file is not in the private directory: %v", err)
+	}
+
+	if err := os.Chmod(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := process.SingleInstanceWithArgs("app", nil); err == nil {
+		t.Fatal("expected a directory others can read to be refused")
+	}
+}
+
+//nolint:exhaustruct // test only
+func TestSupervisor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+
+	dir := t.TempDir()
+	starts := filepath.Join(dir, "starts")
+	stops := filepath.Join(dir, "stops")
+
+	var logged bytes.Buffer
+
+	crashing := process.Service{
+		Name:       "crashing",
+		Command:    shell(`echo start >> "` + starts + `"; exit 1`),
+		Restart:    process.RestartOnFailure,
+		MinBackoff: time.Millisecond,
+	}
+
+	// Each of these records its name when it is stopped.
+	stopping := func(name string) process.Service {
+		cmd := shell(`trap 'echo ` + name + ` >> "` + stops + `"; exit 0' TERM; sleep 30 & wait`)
+		cmd.KillGrace = 5 * time.Second
+
+		return process.Service{Name: name, Command: cmd, Restart: process.RestartAlways}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	supervisor := process.NewSupervisor(charmbracelet.NewMultiLogger(&logged), stopping("first"), crashing, stopping("second"))
+	if err := supervisor.Run(ctx); !errors.Is(err, process.ErrCrashLoop) {
+		t.Fatalf("expected a crash loop, got %v", err)
+	}
+
+	// By default a service is given up on after five starts in a minute.
+	if data, _ := os.ReadFile(starts); strings.Count(string(data), "start") != 5 {
+		t.Fatalf("expected 5 starts, got %q", data)
+	}
+
+	if data, _ := os.ReadFile(stops); string(data) != "second\nfirst\n" {
+		t.Fatalf("unexpected stop order: %q", data)
+	}
+
+	if !strings.Contains(logged.String(), "crash loop") {
+		t.Fatalf("crash loop was not logged: %s", logged.String())
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+
+	last := shell("head -n 1")
+
+	results, err := process.Pipeline(context.Background(), shell(`printf 'b\na\nc\n'`), shell("sort"), last)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 3 || string(results[2].Stdout) != "a\n" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	start := time.Now()
+
+	results, err = process.Pipeline(context.Background(), shell("exec sleep 30"), shell("exit 3"))
+
+	var stageErr process.StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != 1 || results[1].ExitCode != 3 {
+		t.Fatalf("expected the second stage to fail, got %v %+v", err, results)
+	}
+
+	if time.Since(start) > 10*time.Second {
+		t.Fatal("the first stage was not killed")
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+
+	// The background sleep keeps the output open unless the whole tree dies.
+	cmd := shell("sleep 30 & sleep 30")
+	cmd.Timeout = 100 * time.Millisecond
+
+	result, err := process.Run(context.Background(), cmd)
+	if !errors.Is(err, context.DeadlineExceeded) || result.ExitCode != -1 {
+		t.Fatalf("expected a timeout, got %d %v", result.ExitCode, err)
+	}
+
+	if result.Duration > 10*time.Second {
+		t.Fatal("the process tree was not killed")
+	}
+}
+
+func TestRunLines(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+
+	var lines []string
+
+	var logged bytes.Buffer
+
+	tee := filepath.Join(t.TempDir(), "output.log")
+
+	cmd := shell(`printf 'one\r\ntwo\nthree'; echo warning >&2`)
+	cmd = cmd.WithLogger(charmbracelet.NewMultiLogger(&logged), log.InfoLevel, log.WarnLevel)
+	cmd.OnStdoutLine = func(line string) { lines = append(lines, line) }
+	cmd.TeeFile = tee
+
+	if _, err := process.Run(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(lines, []string{"one", "two", "three"}) {
+		t.Fatalf("unexpected lines: %q", lines)
+	}
+
+	if !strings.Contains(logged.String(), "warning process=sh") {
+		t.Fatalf("unexpected log: %q", logged.String())
+	}
+
+	data, err := os.ReadFile(tee)
+	if err != nil || !strings.Contains(string(data), "three") || !strings.Contains(string(data), "warning") {
+		t.Fatalf("unexpected tee file: %q %v", data, err)
+	}
+}
+
+func TestRunKillGrace(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+
+	marker := filepath.Join(t.TempDir(), "terminated")
+
+	// The shell waits on sleep so that it can run its trap when signalled.
+	cmd := shell(`trap 'echo done > "$MARKER"; exit 0' TERM; sleep 30 & wait`)
+	cmd.Env = []string{"MARKER=" + marker}
+	cmd.Timeout = 100 * time.Millisecond
+	cmd.KillGrace = 5 * time.Second
+
+	if _, err := process.Run(context.Background(), cmd); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("the process was not given a chance to exit: %v", err)
+	}
+}
+
+func TestRunKillOnParentExit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+
+	cmd := shell("echo alive")
+	cmd.KillOnParentExit = true
+
+	// Both runs are started from the thread kept for parent death signals.
+	for range 2 {
+		if result, err := process.Run(context.Background(), cmd); err != nil || string(result.Stdout) != "alive\n" {
+			t.Fatalf("unexpected result: %q %v", result.Stdout, err)
+		}
+	}
+}
+
+func TestSpawn(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+
+	if err := os.WriteFile(logFile, []byte("old output\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	spec := process.SpawnSpec{
+		Command:     shell("echo started; echo failed >&2; sleep 3; echo later; exec sleep 30"),
+		PIDFile:     filepath.Join(dir, "app.pid"),
+		Stdout:      logFile,
+		Stderr:      logFile,
+		MaxLogSize:  8,
+		MaxLogFiles: 2,
+	}
+
+	if _, err := process.Spawn(spec); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := process.Spawn(spec); !errors.Is(err, process.ErrAlreadyRunning) {
+		t.Fatalf("expected ErrAlreadyRunning, got %v", err)
+	}
+
+	detached, err := process.OpenPIDFile(spec.PIDFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state, err := detached.Status(); err != nil || state != process.StateRunning {
+		t.Fatalf("expected a running process, got %d %v", state, err)
+	}
+
+	if detached.StartTime.IsZero() && (runtime.GOOS == "linux" || runtime.GOOS == "darwin") {
+		t.Fatal("the start time was not recorded")
+	}
+
+	// A process that started at another time only reuses the pid.
+	if reused := *detached; !reused.StartTime.IsZero() {
+		reused.StartTime = reused.StartTime.Add(-time.Hour)
+
+		if state, err := reused.Status(); err != nil || state != process.StateExited {
+			t.Fatalf("expected a reused pid to be exited, got %d %v", state, err)
+		}
+
+		if err := reused.Signal(os.Interrupt); !errors.Is(err, os.ErrProcessDone) {
+			t.Fatalf("expected a reused pid not to be signalled, got %v", err)
+		}
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		data, _ := os.ReadFile(logFile)
+		if string(data) == "started\nfailed\n" {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("unexpected log %q", data)
+		}
+	}
+
+	if data, err := os.ReadFile(logFile + ".1"); err != nil || string(data) != "old output\n" {
+		t.Fatalf("the log was not rotated: %q %v", data, err)
+	}
+
+	// The log of the running process is rotated once it grows too large.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		current, _ := os.ReadFile(logFile)
+		first, _ := os.ReadFile(logFile + ".1")
+		second, _ := os.ReadFile(logFile + ".2")
+
+		if len(current) == 0 && string(first) == "started\nfailed\n" && string(second) == "old output\n" {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("the running log was not rotated: %q %q %q", current, first, second)
+		}
+	}
+
+	// The process keeps appending to the truncated log.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		data, _ := os.ReadFile(logFile)
+		if string(data) == "later\n" {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("unexpected log after rotation %q", data)
+		}
+	}
+
+	if err := detached.Stop(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if state, err := detached.Status(); err != nil || state != process.StateExited {
+		t.Fatalf("expected an exited process, got %d %v", state, err)
+	}
+
+	if _, err := os.Stat(spec.PIDFile); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("the pid file was not removed: %v", err)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+
+	cmd := shell("i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done; sleep 0.2")
+	cmd.SampleInterval = 20 * time.Millisecond
+
+	result, err := process.Run(context.Background(), cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.UserTime+result.SystemTime == 0 || result.MaxRSS == 0 {
+		t.Fatalf("missing usage: %+v", result)
+	}
+
+	// The exited process is not sampled while it waits to be reaped.
+	empty := func(sample process.RSSSample) bool { return sample.RSS == 0 }
+	if runtime.GOOS == "linux" && (len(result.Samples) < 2 || slices.ContainsFunc(result.Samples, empty)) {
+		t.Fatalf("missing samples: %+v", result.Samples)
+	}
+}
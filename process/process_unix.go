@@ -21,7 +21,55 @@
 package process
 
 import (
+	"errors"
 	"os/exec"
+	"syscall"
+	"time"
 )
 
+const killPollInterval = 50 * time.Millisecond
+
 func setHideWindowAttr(_ *exec.Cmd, _ bool) {}
+
+// setKillTree starts the command in its own process group so cancelling it
+// also kills whatever it spawned. With parentDeath the command is killed
+// when this process dies, on Linux only.
+func setKillTree(cmd *exec.Cmd, grace time.Duration, parentDeath bool) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} //nolint:exhaustruct // wontfix
+
+	if parentDeath {
+		setParentDeathSignal(cmd.SysProcAttr)
+	}
+
+	cmd.Cancel = func() error {
+		return KillTree(cmd.Process.Pid, grace)
+	}
+}
+
+// KillTree ends the process group led by pid. It sends SIGTERM first and
+// SIGKILL to whatever is left once grace has passed, or SIGKILL right away
+// for a zero grace period.
+func KillTree(pid int, grace time.Duration) error {
+	if grace > 0 {
+		if err := syscall.Kill(-pid, syscall.SIGTERM); err != nil {
+			return ignoreGone(err)
+		}
+
+		for deadline := time.Now().Add(grace); time.Now().Before(deadline); time.Sleep(killPollInterval) {
+			if !groupExists(pid) {
+				return nil
+			}
+		}
+	}
+
+	return ignoreGone(syscall.Kill(-pid, syscall.SIGKILL))
+}
+
+// ignoreGone treats a group that no longer exists as killed.
+func ignoreGone(err error) error {
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+
+	return err
+}
@@ -24,9 +24,12 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
@@ -167,3 +170,33 @@ func setHideWindowAttr(cmd *exec.Cmd, hideWindow bool) {
 	// cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: 0x08000000}
 	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: hideWindow} //nolint:exhaustruct // wontfix
 }
+
+// setKillTree has taskkill end the command together with its children when
+// it is cancelled.
+func startCommand(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
+
+func setKillTree(cmd *exec.Cmd, grace time.Duration, _ bool) {
+	cmd.Cancel = func() error {
+		return KillTree(cmd.Process.Pid, grace)
+	}
+}
+
+// KillTree has taskkill end pid and its children. Windows has no equivalent
+// of SIGTERM for console programs, so grace is ignored.
+func KillTree(pid int, _ time.Duration) error {
+	kill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(pid))
+	setHideWindowAttr(kill, true)
+
+	if err := kill.Run(); err != nil {
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			return err
+		}
+
+		return process.Kill()
+	}
+
+	return nil
+}
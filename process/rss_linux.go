@@ -0,0 +1,50 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// statmResident is the position of the resident pages in /proc/<pid>/statm.
+const statmResident = 1
+
+// processRSS reads the resident memory of pid in bytes.
+func processRSS(pid int) (uint64, error) {
+	statm, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "statm"))
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(statm))
+	if len(fields) <= statmResident {
+		return 0, fmt.Errorf("%w: %d", errMalformedStat, pid)
+	}
+
+	pages, err := strconv.ParseUint(fields[statmResident], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return pages * uint64(os.Getpagesize()), nil //nolint:gosec // page size is positive
+}
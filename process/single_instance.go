@@ -0,0 +1,207 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	forwardAttempts = 20
+	forwardDelay    = 100 * time.Millisecond
+	forwardBuffer   = 16
+)
+
+var (
+	ErrAlreadyRunning = errors.New("another instance is already running")
+	errInvalidAppID   = errors.New("invalid application id")
+)
+
+// Instance is the running instance of an application. Args receives the
+// arguments of every later launch and is closed by Close.
+type Instance struct {
+	Args     <-chan []string
+	args     chan []string
+	lock     *os.File
+	listener net.Listener
+	socket   string
+	done     chan struct{}
+	wg       sync.WaitGroup
+	closed   sync.Once
+	closeErr error
+}
+
+// SingleInstance forwards the arguments of this launch, without the program
+// name, to the running instance of appID and returns ErrAlreadyRunning, in
+// which case the caller should exit. Otherwise this is the first instance.
+func SingleInstance(appID string) (*Instance, error) {
+	return SingleInstanceWithArgs(appID, os.Args[1:])
+}
+
+// SingleInstanceWithArgs is SingleInstance forwarding args. The lock file and
+// socket live in $XDG_RUNTIME_DIR or, without it, in a directory of the
+// temporary directory that only the current user can access.
+func SingleInstanceWithArgs(appID string, args []string) (*Instance, error) {
+	if appID == "" || strings.ContainsAny(appID, `/\`) || appID == "." || appID == ".." {
+		return nil, fmt.Errorf("%w: %q", errInvalidAppID, appID)
+	}
+
+	dir, err := runtimeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := os.OpenFile(filepath.Join(dir, appID+".lock"), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := tryLock(lock)
+	if err != nil || !locked {
+		lock.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, forward(filepath.Join(dir, appID+".sock"), args)
+	}
+
+	instance, err := listen(lock, filepath.Join(dir, appID+".sock"))
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+func runtimeDir() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir, nil
+	}
+
+	return privateTempDir()
+}
+
+// forward sends args to the first instance, retrying while it has the lock
+// but is not listening yet.
+func forward(socket string, args []string) error {
+	var err error
+
+	for range forwardAttempts {
+		var conn net.Conn
+
+		if conn, err = net.Dial("unix", socket); err == nil {
+			defer conn.Close()
+
+			if err := json.NewEncoder(conn).Encode(args); err != nil {
+				return err
+			}
+
+			return ErrAlreadyRunning
+		}
+
+		time.Sleep(forwardDelay)
+	}
+
+	return fmt.Errorf("%w: could not forward arguments: %w", ErrAlreadyRunning, err)
+}
+
+func listen(lock *os.File, socket string) (*Instance, error) {
+	// A socket left behind by an instance that crashed is not in use, since
+	// the lock is ours.
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make(chan []string, forwardBuffer)
+	instance := &Instance{
+		Args:     args,
+		args:     args,
+		lock:     lock,
+		listener: listener,
+		socket:   socket,
+		done:     make(chan struct{}),
+		wg:       sync.WaitGroup{},
+		closed:   sync.Once{},
+		closeErr: nil,
+	}
+
+	instance.wg.Add(1)
+
+	go instance.accept()
+
+	return instance, nil
+}
+
+func (i *Instance) accept() {
+	defer i.wg.Done()
+
+	for {
+		conn, err := i.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		var args []string
+
+		err = json.NewDecoder(conn).Decode(&args)
+		conn.Close()
+
+		if err != nil {
+			continue
+		}
+
+		select {
+		case i.args <- args:
+		case <-i.done:
+			return
+		}
+	}
+}
+
+// Close stops receiving arguments and releases the lock, letting the next
+// launch become the first instance. Later calls return the same error.
+func (i *Instance) Close() error {
+	i.closed.Do(func() {
+		close(i.done)
+
+		err := i.listener.Close()
+		i.wg.Wait()
+		close(i.args)
+
+		i.closeErr = errors.Join(err, i.lock.Close())
+	})
+
+	return i.closeErr
+}
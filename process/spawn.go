@@ -0,0 +1,393 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ricochhet/minicommon/filesystem"
+)
+
+// logCheckInterval is how often the logs of a running process are checked
+// for rotation.
+const logCheckInterval = time.Second
+
+// startTolerance is how far the start time read back may differ from the
+// recorded one. Linux derives it from the boot time, which moves by up to a
+// second as the clock is adjusted.
+const startTolerance = time.Second
+
+var (
+	errInvalidPIDFile = errors.New("invalid pid file")
+	errNoStartTime    = errors.New("process start times are not available on this platform")
+)
+
+type State int
+
+const (
+	StateExited State = iota
+	StateRunning
+)
+
+// SpawnSpec describes a process for Spawn. Of the Command, only Name, Args,
+// Dir, Env, ClearEnv, HideWindow and RelativeExecutable are used. Stdout and
+// Stderr name log files the streams are appended to, or are discarded when
+// empty, and may be the same file. A log larger than MaxLogSize is rotated
+// when the process is launched and, for as long as this process lives, while
+// it runs, keeping MaxLogFiles old logs as name.1, name.2 and so on; a zero
+// MaxLogSize never rotates. As the running process keeps its log open, the
+// log is then copied to name.1 and truncated, and output written during the
+// copy may be lost.
+type SpawnSpec struct {
+	Command     Command
+	PIDFile     string
+	Stdout      string
+	Stderr      string
+	MaxLogSize  int64
+	MaxLogFiles int
+}
+
+// Detached is a process started by Spawn, or found through its pid file.
+// StartTime tells it from a later process given the same pid, after it exited
+// or the system restarted, and is zero where it cannot be read.
+type Detached struct {
+	PID       int
+	PIDFile   string
+	StartTime time.Time
+}
+
+// Spawn starts a process that outlives this one, in its own session on unix
+// and without a console on Windows, and writes its pid and start time to
+// spec.PIDFile. It returns ErrAlreadyRunning if the pid file names a running
+// process, or another call is starting it, which is checked under a lock on
+// spec.PIDFile with a .lock suffix. The process is reaped in the background
+// for as long as this one lives.
+func Spawn(spec SpawnSpec) (*Detached, error) {
+	if spec.PIDFile != "" {
+		lock, err := lockPIDFile(spec.PIDFile)
+		if err != nil {
+			return nil, err
+		}
+		defer lock.Close()
+
+		if running, err := OpenPIDFile(spec.PIDFile); err == nil {
+			if state, err := running.Status(); err != nil || state == StateRunning {
+				return nil, errors.Join(fmt.Errorf("%w: pid %d", ErrAlreadyRunning, running.PID), err)
+			}
+		}
+	}
+
+	path, err := spec.Command.path()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := openLog(spec.Stdout, spec.MaxLogSize, spec.MaxLogFiles)
+	if err != nil {
+		return nil, err
+	}
+	defer stdout.Close()
+
+	stderr := stdout
+
+	if spec.Stderr != spec.Stdout {
+		if stderr, err = openLog(spec.Stderr, spec.MaxLogSize, spec.MaxLogFiles); err != nil {
+			return nil, err
+		}
+		defer stderr.Close()
+	}
+
+	cmd := exec.Command(path, spec.Command.Args...)
+	cmd.Dir = spec.Command.Dir
+	cmd.Env = spec.Command.env()
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	setDetached(cmd, spec.Command.HideWindow)
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	detached := &Detached{PID: cmd.Process.Pid, PIDFile: spec.PIDFile, StartTime: time.Time{}}
+	// Until the process is reaped its pid cannot be reused.
+	detached.StartTime, _ = startTime(detached.PID)
+
+	exited := make(chan struct{})
+
+	go func() {
+		cmd.Wait() //nolint:errcheck // only reaps the process
+		close(exited)
+	}()
+
+	if spec.MaxLogSize > 0 {
+		go rotateLogs(spec, exited)
+	}
+
+	if spec.PIDFile != "" {
+		if err := filesystem.WriteFileAtomic(spec.PIDFile, detached.pidFile(), 0o644); err != nil {
+			return detached, err
+		}
+	}
+
+	return detached, nil
+}
+
+// lockPIDFile locks the file next to a pid file that serializes Spawn. The
+// lock is released by closing the returned file.
+func lockPIDFile(path string) (*os.File, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := tryLock(lock)
+	if err != nil || !locked {
+		lock.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%w: %s is locked", ErrAlreadyRunning, path)
+	}
+
+	return lock, nil
+}
+
+// pidFile returns the pid, followed by the start time in nanoseconds if it
+// is known.
+func (d *Detached) pidFile() []byte {
+	data := strconv.Itoa(d.PID) + "\n"
+
+	if !d.StartTime.IsZero() {
+		data += strconv.FormatInt(d.StartTime.UnixNano(), 10) + "\n"
+	}
+
+	return []byte(data)
+}
+
+// OpenPIDFile returns the process named by a pid file written by Spawn.
+func OpenPIDFile(path string) (*Detached, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("%w: %s", errInvalidPIDFile, path)
+	}
+
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil || pid <= 0 {
+		return nil, fmt.Errorf("%w: %s", errInvalidPIDFile, path)
+	}
+
+	detached := &Detached{PID: pid, PIDFile: path, StartTime: time.Time{}}
+
+	if len(fields) == 2 {
+		nanos, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidPIDFile, path)
+		}
+
+		detached.StartTime = time.Unix(0, nanos)
+	}
+
+	return detached, nil
+}
+
+// Status reports whether the process, or on unix anything it started in its
+// session, is still running. A process that reused the pid is not.
+func (d *Detached) Status() (State, error) {
+	if reused, err := d.reused(); err != nil || reused {
+		return StateExited, err
+	}
+
+	return d.status()
+}
+
+// reused reports whether the pid now names another process than the one
+// that was spawned. Without a start time, or a leader to read it from, the
+// pid is trusted; the id of a process group is not reused while it lives.
+func (d *Detached) reused() (bool, error) {
+	if d.StartTime.IsZero() {
+		return false, nil
+	}
+
+	started, err := startTime(d.PID)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) || errors.Is(err, errNoStartTime) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return started.Sub(d.StartTime).Abs() > startTolerance, nil
+}
+
+// Signal sends sig to the process, or returns os.ErrProcessDone if its pid
+// was reused.
+func (d *Detached) Signal(sig os.Signal) error {
+	if reused, err := d.reused(); err != nil {
+		return err
+	} else if reused {
+		return os.ErrProcessDone
+	}
+
+	process, err := os.FindProcess(d.PID)
+	if err != nil {
+		return err
+	}
+
+	return process.Signal(sig)
+}
+
+// Stop ends the process and whatever it started, see KillTree, and removes
+// the pid file.
+func (d *Detached) Stop(grace time.Duration) error {
+	if state, err := d.Status(); err != nil {
+		return err
+	} else if state == StateRunning {
+		if err := KillTree(d.PID, grace); err != nil {
+			return err
+		}
+	}
+
+	if d.PIDFile == "" {
+		return nil
+	}
+
+	if err := os.Remove(d.PIDFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// openLog opens a log for appending, after rotating it if it grew larger
+// than maxSize. An empty name is the null device.
+func openLog(name string, maxSize int64, maxFiles int) (*os.File, error) {
+	if name == "" {
+		return os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	}
+
+	if info, err := os.Stat(name); err == nil && maxSize > 0 && info.Size() > maxSize {
+		if err := rotateLog(name, maxFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	return os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+}
+
+// rotateLogs rotates the logs of a running process that grew larger than
+// MaxLogSize until it exits. Failures are retried at the next check.
+func rotateLogs(spec SpawnSpec, exited <-chan struct{}) {
+	ticker := time.NewTicker(logCheckInterval)
+	defer ticker.Stop()
+
+	names := []string{spec.Stdout}
+	if spec.Stderr != spec.Stdout {
+		names = append(names, spec.Stderr)
+	}
+
+	for {
+		select {
+		case <-exited:
+			return
+		case <-ticker.C:
+		}
+
+		for _, name := range names {
+			if info, err := os.Stat(name); err == nil && info.Size() > spec.MaxLogSize {
+				truncateLog(name, spec.MaxLogFiles) //nolint:errcheck // retried
+			}
+		}
+	}
+}
+
+// rotateLog shifts the old logs and moves name to name.1.
+func rotateLog(name string, maxFiles int) error {
+	if maxFiles <= 0 {
+		return os.Remove(name)
+	}
+
+	if err := shiftLogs(name, maxFiles); err != nil {
+		return err
+	}
+
+	return os.Rename(name, name+".1")
+}
+
+// truncateLog shifts the old logs and copies name to name.1 before
+// truncating it, which a process appending to name keeps writing to.
+func truncateLog(name string, maxFiles int) error {
+	if maxFiles > 0 {
+		if err := shiftLogs(name, maxFiles); err != nil {
+			return err
+		}
+
+		if err := copyLog(name, name+".1"); err != nil {
+			return err
+		}
+	}
+
+	return os.Truncate(name, 0)
+}
+
+func copyLog(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		return errors.Join(err, out.Close())
+	}
+
+	return out.Close()
+}
+
+// shiftLogs moves name.1 to name.2 and so on, dropping the oldest.
+func shiftLogs(name string, maxFiles int) error {
+	if err := os.Remove(name + "." + strconv.Itoa(maxFiles)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for i := maxFiles - 1; i > 0; i-- {
+		if err := os.Rename(name+"."+strconv.Itoa(i), name+"."+strconv.Itoa(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
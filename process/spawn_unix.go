@@ -0,0 +1,51 @@
+//go:build !windows
+
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// setDetached starts the command in a session of its own, so it keeps
+// running when this process and its terminal go away.
+func setDetached(cmd *exec.Cmd, _ bool) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true} //nolint:exhaustruct // wontfix
+}
+
+// status reports whether the process, or anything it started in its
+// session, is still running.
+func (d *Detached) status() (State, error) {
+	// The session leader also leads its process group.
+	err := syscall.Kill(-d.PID, 0)
+	if errors.Is(err, syscall.EPERM) {
+		return StateRunning, nil
+	} else if err != nil {
+		return StateExited, ignoreGone(err)
+	}
+
+	if !groupExists(d.PID) {
+		return StateExited, nil
+	}
+
+	return StateRunning, nil
+}
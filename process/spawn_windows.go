@@ -0,0 +1,86 @@
+//go:build windows
+
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// stillActive is the exit code of a process that has not exited.
+const stillActive = 259
+
+// setDetached starts the command without a console and outside of this
+// process's console group.
+func setDetached(cmd *exec.Cmd, hideWindow bool) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{ //nolint:exhaustruct // wontfix
+		HideWindow:    hideWindow,
+		CreationFlags: windows.DETACHED_PROCESS | windows.CREATE_NEW_PROCESS_GROUP,
+	}
+}
+
+// status reports whether the process is still running.
+func (d *Detached) status() (State, error) {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(d.PID)) //nolint:gosec // pids fit
+	if errors.Is(err, windows.ERROR_INVALID_PARAMETER) {
+		return StateExited, nil
+	} else if errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+		return StateRunning, nil
+	} else if err != nil {
+		return StateExited, err
+	}
+	defer windows.CloseHandle(handle) //nolint:errcheck // wontfix
+
+	var code uint32
+	if err := windows.GetExitCodeProcess(handle, &code); err != nil {
+		return StateExited, err
+	}
+
+	if code == stillActive {
+		return StateRunning, nil
+	}
+
+	return StateExited, nil
+}
+
+// startTime returns when pid was created.
+func startTime(pid int) (time.Time, error) {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid)) //nolint:gosec // pids fit
+	if errors.Is(err, windows.ERROR_INVALID_PARAMETER) {
+		return time.Time{}, fmt.Errorf("%w: pid %d", os.ErrNotExist, pid)
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	defer windows.CloseHandle(handle) //nolint:errcheck // wontfix
+
+	var creation, exit, kernel, user windows.Filetime
+	if err := windows.GetProcessTimes(handle, &creation, &exit, &kernel, &user); err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(0, creation.Nanoseconds()), nil
+}
@@ -0,0 +1,43 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// startTime returns when pid was started. The kernel returns nothing rather
+// than an error for a pid that is not in use.
+func startTime(pid int) (time.Time, error) {
+	proc, err := unix.SysctlKinfoProc("kern.proc.pid", pid)
+	if errors.Is(err, unix.EIO) {
+		return time.Time{}, fmt.Errorf("%w: pid %d", os.ErrNotExist, pid)
+	} else if err != nil {
+		return time.Time{}, err
+	}
+
+	start := proc.Proc.P_starttime
+
+	return time.Unix(start.Sec, int64(start.Usec)*int64(time.Microsecond)), nil
+}
@@ -0,0 +1,256 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ricochhet/minicommon/charmbracelet"
+)
+
+const (
+	defaultMinBackoff  = time.Second
+	defaultMaxBackoff  = time.Minute
+	defaultCrashLoop   = 5
+	defaultCrashWindow = time.Minute
+)
+
+var ErrCrashLoop = errors.New("service is crash looping")
+
+type RestartPolicy int
+
+const (
+	RestartAlways RestartPolicy = iota
+	RestartOnFailure
+	RestartNever
+)
+
+// Service is a command kept running by a Supervisor. Restarts wait from
+// MinBackoff up to MaxBackoff, doubling after each failure, one second and one
+// minute if unset. More than CrashLoop starts within CrashWindow, five and one
+// minute if unset, give up on the service; a negative CrashLoop never does.
+// HealthCheck, if set, is called every HealthInterval while the command runs
+// and an error restarts it.
+type Service struct {
+	Name           string
+	Command        Command
+	Restart        RestartPolicy
+	MinBackoff     time.Duration
+	MaxBackoff     time.Duration
+	CrashLoop      int
+	CrashWindow    time.Duration
+	HealthCheck    func(ctx context.Context) error
+	HealthInterval time.Duration
+}
+
+// Supervisor runs its services until the context passed to Run is done and
+// logs their events to Logger, or the shared logger if it is nil.
+type Supervisor struct {
+	Services []Service
+	Logger   *charmbracelet.MultiLogger
+}
+
+func NewSupervisor(logger *charmbracelet.MultiLogger, services ...Service) *Supervisor {
+	return &Supervisor{Services: services, Logger: logger}
+}
+
+// Run starts every service and returns once ctx is done or none of them is
+// left running. Services are stopped in the reverse of their order, each
+// given its command's KillGrace to exit before the next one is stopped. The
+// error joins ErrCrashLoop for every service that was given up on.
+func (s *Supervisor) Run(ctx context.Context) error {
+	cancels := make([]context.CancelFunc, len(s.Services))
+	dones := make([]chan struct{}, len(s.Services))
+	errs := make([]error, len(s.Services))
+
+	for i, service := range s.Services {
+		serviceCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		cancels[i], dones[i] = cancel, make(chan struct{})
+
+		go func() {
+			defer close(dones[i])
+
+			errs[i] = s.supervise(serviceCtx, service)
+		}()
+	}
+
+	finished := make(chan struct{})
+
+	go func() {
+		for _, done := range dones {
+			<-done
+		}
+
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		for i := len(s.Services) - 1; i >= 0; i-- {
+			s.logger().Info("stopping", "service", s.Services[i].Name)
+			cancels[i]()
+			<-dones[i]
+		}
+	}
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *Supervisor) logger() *charmbracelet.MultiLogger {
+	if s.Logger == nil {
+		return charmbracelet.SharedLogger
+	}
+
+	return s.Logger
+}
+
+func (s *Supervisor) supervise(ctx context.Context, service Service) error {
+	log := s.logger()
+	backoff := service.minBackoff()
+
+	var starts []time.Time
+
+	for {
+		start := time.Now()
+		starts = append(recentStarts(starts, start, service.crashWindow()), start)
+
+		if service.crashLoop() > 0 && len(starts) > service.crashLoop() {
+			log.Error("crash loop, giving up", "service", service.Name, "starts", len(starts), "window", service.crashWindow())
+			return fmt.Errorf("%w: %s", ErrCrashLoop, service.Name)
+		}
+
+		log.Info("starting", "service", service.Name)
+
+		err := s.runOnce(ctx, service)
+		if ctx.Err() != nil {
+			log.Info("stopped", "service", service.Name)
+			return nil
+		}
+
+		if err != nil {
+			log.Warn("exited", "service", service.Name, "err", err)
+		} else {
+			log.Info("exited", "service", service.Name)
+		}
+
+		if service.Restart == RestartNever || (service.Restart == RestartOnFailure && err == nil) {
+			return nil
+		}
+
+		// A command that stayed up for longer than the longest backoff is
+		// treated as healthy again.
+		if time.Since(start) > service.maxBackoff() {
+			backoff = service.minBackoff()
+		}
+
+		log.Info("restarting", "service", service.Name, "in", backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, service.maxBackoff()) //nolint:mnd // exponential backoff
+	}
+}
+
+// runOnce runs the command until it exits or fails its health check.
+func (s *Supervisor) runOnce(ctx context.Context, service Service) error {
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	if service.HealthCheck != nil && service.HealthInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(service.HealthInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-runCtx.Done():
+					return
+				case <-ticker.C:
+				}
+
+				if err := service.HealthCheck(runCtx); err != nil && runCtx.Err() == nil {
+					s.logger().Warn("health check failed", "service", service.Name, "err", err)
+					cancel(err)
+
+					return
+				}
+			}
+		}()
+	}
+
+	_, err := Run(runCtx, service.Command)
+	if cause := context.Cause(runCtx); cause != nil && ctx.Err() == nil {
+		return cause
+	}
+
+	return err
+}
+
+// recentStarts drops the starts that fall outside window before now.
+func recentStarts(starts []time.Time, now time.Time, window time.Duration) []time.Time {
+	for len(starts) > 0 && now.Sub(starts[0]) > window {
+		starts = starts[1:]
+	}
+
+	return starts
+}
+
+func (s Service) minBackoff() time.Duration {
+	if s.MinBackoff > 0 {
+		return s.MinBackoff
+	}
+
+	return defaultMinBackoff
+}
+
+func (s Service) maxBackoff() time.Duration {
+	if s.MaxBackoff > 0 {
+		return max(s.MaxBackoff, s.minBackoff())
+	}
+
+	return max(defaultMaxBackoff, s.minBackoff())
+}
+
+func (s Service) crashLoop() int {
+	if s.CrashLoop != 0 {
+		return s.CrashLoop
+	}
+
+	return defaultCrashLoop
+}
+
+func (s Service) crashWindow() time.Duration {
+	if s.CrashWindow > 0 {
+		return s.CrashWindow
+	}
+
+	return defaultCrashWindow
+}
@@ -0,0 +1,82 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"sync"
+	"time"
+)
+
+// RSSSample is the resident memory of a process, in bytes, Elapsed after it
+// was started.
+type RSSSample struct {
+	Elapsed time.Duration
+	RSS     uint64
+}
+
+type sampler struct {
+	samples []RSSSample
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// startSampler reads the resident memory of pid every interval until stop is
+// called or the process exits. A zero interval, or a platform without /proc,
+// records nothing.
+func startSampler(pid int, start time.Time, interval time.Duration) *sampler {
+	s := &sampler{samples: nil, done: make(chan struct{}), wg: sync.WaitGroup{}}
+
+	if interval <= 0 {
+		return s
+	}
+
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			// A process that exited has no memory left until it is reaped.
+			rss, err := processRSS(pid)
+			if err != nil || rss == 0 {
+				return
+			}
+
+			s.samples = append(s.samples, RSSSample{Elapsed: time.Since(start), RSS: rss})
+
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *sampler) stop() []RSSSample {
+	close(s.done)
+	s.wg.Wait()
+
+	return s.samples
+}
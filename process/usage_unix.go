@@ -0,0 +1,52 @@
+//go:build !windows
+
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package process
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// blockSize is the unit of the block counts in rusage.
+const blockSize = 512
+
+// setUsage copies the CPU times and rusage of a finished process into
+// result. Linux and the BSDs report the maximum resident memory in
+// kilobytes, macOS in bytes. macOS counts I/O operations rather than blocks,
+// so no byte counts are reported there.
+func setUsage(result *Result, state *os.ProcessState) {
+	result.UserTime, result.SystemTime = state.UserTime(), state.SystemTime()
+
+	usage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return
+	}
+
+	result.MaxRSS = uint64(usage.Maxrss) //nolint:gosec // never negative
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		return
+	}
+
+	result.MaxRSS *= 1024
+	result.ReadBytes = uint64(usage.Inblock) * blockSize  //nolint:gosec // never negative
+	result.WriteBytes = uint64(usage.Oublock) * blockSize //nolint:gosec // never negative
+}
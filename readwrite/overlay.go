@@ -0,0 +1,118 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package readwrite
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/pe"
+	"errors"
+	"io"
+)
+
+var errUnknownExecutable = errors.New("unknown executable format")
+
+// ELF header offsets of e_shoff, e_shentsize and e_shnum.
+const (
+	elf32SHOff     = 0x20
+	elf32SHEntSize = 0x2E
+	elf64SHOff     = 0x28
+	elf64SHEntSize = 0x3A
+	elfHeaderSize  = 0x40
+)
+
+// OverlayOffset returns the offset at which data appended to a PE or ELF
+// executable begins, i.e. the end of the last byte the image itself uses.
+func OverlayOffset(r io.ReaderAt) (int64, error) {
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return -1, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("MZ")):
+		file, err := pe.NewFile(r)
+		if err != nil {
+			return -1, err
+		}
+
+		return PEOverlayOffset(*file), nil
+	case string(magic) == elf.ELFMAG:
+		return ELFOverlayOffset(r)
+	}
+
+	return -1, errUnknownExecutable
+}
+
+func PEOverlayOffset(file pe.File) int64 { //nolint:gocritic // matches the other pe.File helpers
+	var end int64
+
+	switch header := file.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		end = int64(header.SizeOfHeaders)
+	case *pe.OptionalHeader64:
+		end = int64(header.SizeOfHeaders)
+	}
+
+	for _, section := range file.Sections {
+		end = max(end, int64(section.Offset)+int64(section.Size))
+	}
+
+	return end
+}
+
+func ELFOverlayOffset(r io.ReaderAt) (int64, error) {
+	file, err := elf.NewFile(r)
+	if err != nil {
+		return -1, err
+	}
+
+	var end int64
+
+	for _, section := range file.Sections {
+		if section.Type != elf.SHT_NOBITS {
+			end = max(end, int64(section.Offset+section.FileSize)) //nolint:gosec // offsets fit the file
+		}
+	}
+
+	for _, prog := range file.Progs {
+		end = max(end, int64(prog.Off+prog.Filesz)) //nolint:gosec // offsets fit the file
+	}
+
+	header := make([]byte, elfHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return -1, err
+	}
+
+	var shoff uint64
+
+	var shentsize, shnum uint16
+
+	if file.Class == elf.ELFCLASS64 {
+		shoff = file.ByteOrder.Uint64(header[elf64SHOff:])
+		shentsize = file.ByteOrder.Uint16(header[elf64SHEntSize:])
+		shnum = file.ByteOrder.Uint16(header[elf64SHEntSize+2:])
+	} else {
+		shoff = uint64(file.ByteOrder.Uint32(header[elf32SHOff:]))
+		shentsize = file.ByteOrder.Uint16(header[elf32SHEntSize:])
+		shnum = file.ByteOrder.Uint16(header[elf32SHEntSize+2:])
+	}
+
+	return max(end, int64(shoff+uint64(shentsize)*uint64(shnum))), nil //nolint:gosec // offsets fit the file
+}
@@ -0,0 +1,297 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sevenzip
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// The x86 branch converters follow Bra86.c and Bcj2Dec.c from the LZMA SDK.
+const (
+	x86InstructionSize = 5
+	bcj2Streams        = 4
+	bcj2JumpProb       = 256
+	bcj2OtherProb      = 257
+)
+
+//nolint:gochecknoglobals // lookup tables
+var (
+	x86MaskToAllowed   = [8]bool{true, true, true, false, true, false, false, false}
+	x86MaskToBitNumber = [8]uint32{0, 1, 2, 2, 3, 3, 3, 3}
+)
+
+func x86Test(b byte) bool {
+	return b == 0 || b == 0xFF
+}
+
+// x86Convert decodes the CALL and JMP targets in data and returns how many
+// bytes were converted; the rest must be passed again with more data.
+func x86Convert(data []byte, ip uint32, state *uint32) int {
+	if len(data) < x86InstructionSize {
+		return 0
+	}
+
+	ip += x86InstructionSize
+	limit := len(data) - 4
+	pos, prevPos := 0, -1
+	prevMask := *state & 7
+
+	for {
+		for pos < limit && data[pos]&0xFE != 0xE8 {
+			pos++
+		}
+
+		if pos >= limit {
+			break
+		}
+
+		if d := pos - prevPos; d > 3 {
+			prevMask = 0
+		} else {
+			prevMask = (prevMask << (d - 1)) & 7
+
+			if prevMask != 0 {
+				b := data[pos+4-int(x86MaskToBitNumber[prevMask])]
+				if !x86MaskToAllowed[prevMask] || x86Test(b) {
+					prevPos = pos
+					prevMask = (prevMask<<1)&7 | 1
+					pos++
+
+					continue
+				}
+			}
+		}
+
+		prevPos = pos
+
+		if !x86Test(data[pos+4]) {
+			prevMask = (prevMask<<1)&7 | 1
+			pos++
+
+			continue
+		}
+
+		src := binary.LittleEndian.Uint32(data[pos+1:])
+
+		var dest uint32
+
+		for {
+			dest = src - (ip + uint32(pos)) //nolint:gosec // positions wrap like the reference decoder
+			if prevMask == 0 {
+				break
+			}
+
+			index := x86MaskToBitNumber[prevMask] * 8
+			if !x86Test(byte(dest >> (24 - index))) {
+				break
+			}
+
+			src = dest ^ (1<<(32-index) - 1)
+		}
+
+		dest &= 0x01FFFFFF
+		if dest&0x01000000 != 0 {
+			dest |= 0xFF000000
+		}
+
+		binary.LittleEndian.PutUint32(data[pos+1:], dest)
+		pos += x86InstructionSize
+	}
+
+	if d := pos - prevPos; d > 3 {
+		*state = 0
+	} else {
+		*state = (prevMask << (d - 1)) & 7
+	}
+
+	return pos
+}
+
+type bcjReader struct {
+	src   io.Reader
+	buf   []byte
+	start int
+	conv  int
+	end   int
+	ip    uint32
+	state uint32
+	err   error
+}
+
+func newBCJReader(r io.Reader, props []byte) io.Reader {
+	var ip uint32
+	if len(props) >= 4 { //nolint:mnd // optional start offset
+		ip = binary.LittleEndian.Uint32(props)
+	}
+
+	//nolint:exhaustruct // positions start at zero
+	return &bcjReader{src: r, buf: make([]byte, lzmaBufferSize), ip: ip}
+}
+
+func (b *bcjReader) Read(p []byte) (int, error) {
+	for b.start == b.conv {
+		if b.err != nil {
+			return 0, b.err
+		}
+
+		b.end = copy(b.buf, b.buf[b.conv:b.end])
+		b.start, b.conv = 0, 0
+
+		n, err := b.src.Read(b.buf[b.end:])
+		b.end += n
+		b.err = err
+
+		b.conv = x86Convert(b.buf[:b.end], b.ip, &b.state)
+		b.ip += uint32(b.conv) //nolint:gosec // positions wrap like the reference decoder
+
+		// The last few bytes of the stream cannot start an instruction.
+		if b.err != nil {
+			b.conv = b.end
+		}
+	}
+
+	n := copy(p, b.buf[b.start:b.conv])
+	b.start += n
+
+	return n, nil
+}
+
+func isJump(prev, b byte) bool {
+	return b&0xFE == 0xE8 || (prev == 0x0F && b&0xF0 == 0x80)
+}
+
+// bcj2Reader joins the four BCJ2 streams: the main stream with the branch
+// targets removed, the CALL and JMP targets, and the range coded flags that
+// tell whether a branch was converted.
+type bcj2Reader struct {
+	main      *bufio.Reader
+	call      io.Reader
+	jump      io.Reader
+	rc        rangeDecoder
+	probs     [2 + 256]prob
+	prev      byte
+	pos       uint32
+	tail      []byte
+	remaining uint64
+	target    [4]byte
+}
+
+func newBCJ2Reader(inputs []io.Reader, size uint64) (io.Reader, error) {
+	//nolint:exhaustruct // decoding state starts at zero
+	z := &bcj2Reader{
+		main:      bufio.NewReaderSize(inputs[0], lzmaBufferSize),
+		call:      inputs[1],
+		jump:      inputs[2],
+		remaining: size,
+	}
+
+	initProbs(z.probs[:])
+
+	if err := z.rc.init(bufio.NewReader(inputs[3])); err != nil {
+		return nil, err
+	}
+
+	return z, nil
+}
+
+func (z *bcj2Reader) Read(p []byte) (int, error) {
+	n := 0
+
+	for n < len(p) {
+		if len(z.tail) > 0 {
+			c := copy(p[n:], z.tail)
+			z.tail = z.tail[c:]
+			n += c
+
+			continue
+		}
+
+		if z.remaining == 0 {
+			break
+		}
+
+		b, err := z.main.ReadByte()
+		if err != nil {
+			return n, unexpectedEOF(err)
+		}
+
+		p[n] = b
+		n++
+		z.pos++
+		z.remaining--
+
+		if !isJump(z.prev, b) || z.remaining == 0 {
+			z.prev = b
+			continue
+		}
+
+		if err := z.branch(b); err != nil {
+			return n, err
+		}
+	}
+
+	if n == 0 && z.remaining == 0 {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+func (z *bcj2Reader) branch(b byte) error {
+	p := &z.probs[bcj2OtherProb]
+
+	switch b {
+	case 0xE8:
+		p = &z.probs[z.prev]
+	case 0xE9:
+		p = &z.probs[bcj2JumpProb]
+	}
+
+	converted := z.rc.bit(p)
+	if z.rc.err != nil {
+		return z.rc.err
+	}
+
+	if converted == 0 {
+		z.prev = b
+		return nil
+	}
+
+	src := z.jump
+	if b == 0xE8 {
+		src = z.call
+	}
+
+	if _, err := io.ReadFull(src, z.target[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+
+	dest := binary.BigEndian.Uint32(z.target[:]) - (z.pos + 4) //nolint:mnd // the target is relative to the next instruction
+	binary.LittleEndian.PutUint32(z.target[:], dest)
+
+	size := min(uint64(len(z.target)), z.remaining)
+	z.tail = z.target[:size]
+	z.remaining -= size
+	z.pos += uint32(size)
+	z.prev = z.target[size-1]
+
+	return nil
+}
@@ -0,0 +1,270 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sevenzip
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ricochhet/minicommon/process"
+)
+
+const (
+	EnvBinary         = "SEVENZIP_BINARY"
+	probeTimeout      = 10 * time.Second
+	sevenZipKillGrace = 5 * time.Second
+	progressSwitches  = 15
+	probeCaptureLimit = 64 << 10
+)
+
+//nolint:gochecknoglobals // wontfix
+var (
+	bannerPattern  = regexp.MustCompile(`7-Zip(?: \((\w)\))?(?: \[\d+\])? (\d+)\.(\d+)`)
+	reducedFormats = map[string][]string{
+		"r": {"7z", "xz", "lzma", "split"},
+		"a": {"7z", "xz", "lzma", "split", "zip", "gzip", "bzip2", "tar"},
+	}
+	defaultResolver = NewResolver()
+	resolverMutex   sync.Mutex
+)
+
+// Binary is a 7z executable and what its banner says about it. Formats is
+// nil for the full 7z and 7zz builds, which support every format, and lists
+// what the reduced 7za and 7zr builds can handle. A zero Major means the
+// version could not be read.
+type Binary struct {
+	Path     string
+	Version  string
+	Major    int
+	Minor    int
+	P7zip    bool
+	Formats  []string
+	relative bool
+}
+
+func (b *Binary) Supports(format string) bool {
+	return b.Formats == nil || slices.Contains(b.Formats, format)
+}
+
+// command runs the binary with its output captured and, unless stdout is
+// nil, also written to stdout.
+func (b *Binary) command(dir string, stdout io.Writer, args ...string) process.Command {
+	return process.Command{
+		Name:               b.Path,
+		Args:               args,
+		Dir:                dir,
+		Env:                nil,
+		ClearEnv:           false,
+		Stdin:              nil,
+		Stdout:             stdout,
+		Stderr:             nil,
+		OnStdoutLine:       nil,
+		OnStderrLine:       nil,
+		TeeFile:            "",
+		Capture:            true,
+		CaptureLimit:       0,
+		Timeout:            0,
+		KillGrace:          sevenZipKillGrace,
+		KillOnParentExit:   true,
+		HideWindow:         true,
+		RelativeExecutable: b.relative,
+		SampleInterval:     0,
+	}
+}
+
+// progress reports whether the binary understands -bsp and -bb, which were
+// added in 7-Zip 15. Binaries of unknown version are assumed to be recent.
+func (b *Binary) progress() bool {
+	return b.Major == 0 || b.Major >= progressSwitches
+}
+
+// Resolver finds a 7z binary. The path in the Env variable is tried first,
+// then each of Names in Dirs, relative to the executable and then to the
+// working directory, and finally Names on the PATH. The first binary found
+// is probed once and cached.
+type Resolver struct {
+	Env    string
+	Names  []string
+	Dirs   []string
+	mu     sync.Mutex
+	binary *Binary
+	inputs string
+}
+
+func NewResolver() *Resolver {
+	return &Resolver{
+		Env:    EnvBinary,
+		Names:  []string{"7z", "7zz", "7za", "7zr"},
+		Dirs:   redistDirs(),
+		mu:     sync.Mutex{},
+		binary: nil,
+		inputs: "",
+	}
+}
+
+// redistDirs returns redist/<GOOS>-<GOARCH>, and the redist/win64 directory
+// that earlier versions used on 64-bit Windows.
+func redistDirs() []string {
+	dirs := []string{filepath.Join("redist", runtime.GOOS+"-"+runtime.GOARCH)}
+
+	if runtime.GOOS == "windows" && runtime.GOARCH == "amd64" {
+		dirs = append(dirs, filepath.Join("redist", "win64"))
+	}
+
+	return dirs
+}
+
+// SetResolver replaces the resolver used by the Sz functions.
+func SetResolver(r *Resolver) {
+	resolverMutex.Lock()
+	defer resolverMutex.Unlock()
+
+	defaultResolver = r
+}
+
+// ResolveBinary returns the binary found by the resolver set with SetResolver.
+func ResolveBinary() (*Binary, error) {
+	resolverMutex.Lock()
+	r := defaultResolver
+	resolverMutex.Unlock()
+
+	return r.Resolve()
+}
+
+// Resolve returns the cached binary while it still exists and the variable
+// named by Env, PATH and the working directory are unchanged, and searches
+// again otherwise. It returns ErrSevenZipNotFound when there is none.
+func (r *Resolver) Resolve() (*Binary, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	inputs := r.searchInputs()
+
+	if r.binary != nil && r.inputs == inputs {
+		if _, err := os.Stat(r.binary.Path); err == nil {
+			return r.binary, nil
+		}
+	}
+
+	r.binary = nil
+
+	path, ok := r.find()
+	if !ok {
+		return nil, ErrSevenZipNotFound
+	}
+
+	r.binary, r.inputs = probe(path), inputs
+
+	return r.binary, nil
+}
+
+// searchInputs describes what find depends on besides the file system.
+func (r *Resolver) searchInputs() string {
+	wd, _ := os.Getwd()
+
+	return strings.Join([]string{os.Getenv(r.Env), os.Getenv("PATH"), wd}, "\x00")
+}
+
+func (r *Resolver) find() (string, bool) {
+	if path := os.Getenv(r.Env); r.Env != "" && path != "" && isFile(path) {
+		return path, true
+	}
+
+	var bases []string
+
+	if exe, err := os.Executable(); err == nil {
+		bases = append(bases, filepath.Dir(exe))
+	}
+
+	if cwd, err := os.Getwd(); err == nil {
+		bases = append(bases, cwd)
+	}
+
+	for _, base := range bases {
+		for _, dir := range r.Dirs {
+			for _, name := range r.Names {
+				if path := filepath.Join(base, dir, executableName(name)); isFile(path) {
+					return path, true
+				}
+			}
+		}
+	}
+
+	for _, name := range r.Names {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, true
+		}
+	}
+
+	return "", false
+}
+
+func executableName(name string) string {
+	if runtime.GOOS == "windows" {
+		return name + ".exe"
+	}
+
+	return name
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// probe runs the binary without arguments, which prints its banner, such as
+// "7-Zip (a) 23.01 (x64)" or "7-Zip [64] 16.02" followed by "p7zip Version".
+func probe(path string) *Binary {
+	binary := &Binary{Path: path, Version: "", Major: 0, Minor: 0, P7zip: false, Formats: nil, relative: false}
+
+	cmd := binary.command("", nil)
+	cmd.Timeout = probeTimeout
+	cmd.CaptureLimit = probeCaptureLimit
+
+	// 7z exits with an error without a command on some versions, so only the
+	// banner matters.
+	result, _ := process.Run(context.Background(), cmd)
+
+	parseBanner(binary, string(result.Stdout))
+
+	return binary
+}
+
+func parseBanner(binary *Binary, output string) {
+	match := bannerPattern.FindStringSubmatch(output)
+	if match == nil {
+		return
+	}
+
+	binary.Major, _ = strconv.Atoi(match[2])
+	binary.Minor, _ = strconv.Atoi(match[3])
+	binary.Version = match[2] + "." + match[3]
+	binary.P7zip = strings.Contains(output, "p7zip")
+	binary.Formats = reducedFormats[match[1]]
+}
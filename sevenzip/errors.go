@@ -27,6 +27,14 @@ const (
 	ProcessNotFound
 	CouldNotExtract
 	CouldNotCompress
+	Cancelled
 )
 
-var ErrSevenZipNotFound = errors.New("7zip was not found")
+var (
+	ErrSevenZipNotFound  = errors.New("7zip was not found")
+	ErrUnsupportedMethod = errors.New("unsupported 7z compression method")
+	ErrChecksum          = errors.New("7z checksum error")
+	ErrUnsupportedFormat = errors.New("archive format not supported by this 7z binary")
+	errNotSevenZip       = errors.New("not a 7z archive")
+	errCorruptHeader     = errors.New("corrupt 7z header")
+)
@@ -0,0 +1,397 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sevenzip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ricochhet/minicommon/process"
+)
+
+const (
+	listSeparator = "----------"
+	attrReadOnly  = 0x01
+	attrHidden    = 0x02
+	attrSystem    = 0x04
+	attrArchive   = 0x20
+)
+
+//nolint:gochecknoglobals // wontfix
+var (
+	testErrorPattern = regexp.MustCompile(`^ERROR: (.+?) : (.+)$`)
+	listTimeLayouts  = []string{"2006-01-02 15:04:05.999999999", "2006-01-02 15:04:05"}
+	methodNames      = map[string]string{
+		methodCopy:  "Copy",
+		methodLZMA:  "LZMA",
+		methodLZMA2: "LZMA2",
+		methodBCJ:   "BCJ",
+		methodBCJ2:  "BCJ2",
+		methodAES:   "7zAES",
+	}
+)
+
+// Entry is one file of an archive as reported by "7z l -slt". Attributes and
+// Method are kept in 7z's notation, such as "A -rw-r--r--" and "LZMA2:24".
+type Entry struct {
+	Path       string
+	Size       uint64
+	PackedSize uint64
+	CRC32      uint32
+	Attributes string
+	Method     string
+	Encrypted  bool
+	Modified   time.Time
+	IsDir      bool
+}
+
+type EntryError struct {
+	Name string
+	Err  error
+}
+
+func (e EntryError) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+func (e EntryError) Unwrap() error {
+	return e.Err
+}
+
+// SzList lists the entries of archive with 7z, or with the native reader when
+// 7z is not available.
+func SzList(archive string) ([]Entry, error) {
+	return SzListWithContext(context.TODO(), archive)
+}
+
+// SzListWithContext kills 7z once ctx is done and returns the context's error.
+func SzListWithContext(ctx context.Context, archive string) ([]Entry, error) {
+	bin, err := ResolveBinary()
+	if err != nil {
+		return nativeList(archive)
+	}
+
+	result, err := process.Run(ctx, bin.command("", nil, "l", "-slt", archive))
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(result.Stderr)))
+	}
+
+	return parseList(string(result.Stdout))
+}
+
+// SzTest verifies every entry of archive and returns one EntryError for each
+// entry that failed. The error is only set when the archive could not be
+// tested at all.
+func SzTest(archive string) ([]EntryError, error) {
+	return SzTestWithContext(context.TODO(), archive)
+}
+
+// SzTestWithContext stops testing once ctx is done and returns the context's
+// error.
+func SzTestWithContext(ctx context.Context, archive string) ([]EntryError, error) {
+	bin, err := ResolveBinary()
+	if err != nil {
+		return nativeTest(ctx, archive)
+	}
+
+	// Newer versions of 7z print errors to stderr and older ones to stdout.
+	result, err := process.Run(ctx, bin.command("", nil, "t", archive))
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	failed := parseTest(string(result.Stdout) + "\n" + string(result.Stderr))
+
+	// 7z exits with a fatal error code when any entry fails.
+	if err != nil && len(failed) == 0 {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(result.Stderr)))
+	}
+
+	return failed, nil
+}
+
+// parseList reads the blocks of "Key = Value" lines that follow the separator
+// in the output of "7z l -slt". The blocks before it describe the archive.
+func parseList(output string) ([]Entry, error) {
+	var entries []Entry
+
+	var entry *Entry
+
+	listing := false
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if !listing {
+			listing = line == listSeparator
+			continue
+		}
+
+		key, value, found := strings.Cut(line, " = ")
+		if !found {
+			key, found = strings.CutSuffix(line, " =")
+		}
+
+		if !found {
+			continue
+		}
+
+		if key == "Path" {
+			entries = append(entries, Entry{}) //nolint:exhaustruct // filled in below
+			entry = &entries[len(entries)-1]
+		}
+
+		if entry == nil {
+			continue
+		}
+
+		if err := entry.set(key, value); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, line)
+		}
+	}
+
+	return entries, nil
+}
+
+func (e *Entry) set(key, value string) error {
+	var err error
+
+	switch key {
+	case "Path":
+		e.Path = value
+	case "Size":
+		e.Size, err = parseSize(value)
+	case "Packed Size":
+		e.PackedSize, err = parseSize(value)
+	case "CRC":
+		if value != "" {
+			var crc uint64
+			crc, err = strconv.ParseUint(value, 16, 32)
+			e.CRC32 = uint32(crc)
+		}
+	case "Attributes":
+		e.Attributes = value
+		e.IsDir = e.IsDir || strings.HasPrefix(value, "D")
+	case "Folder":
+		e.IsDir = value == "+"
+	case "Method":
+		e.Method = value
+	case "Encrypted":
+		e.Encrypted = value == "+"
+	case "Modified":
+		e.Modified, err = parseTime(value)
+	}
+
+	return err
+}
+
+func parseSize(value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// parseTime reads modification times, which 7z prints in local time and
+// newer versions with a fraction of a second.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	var err error
+
+	for _, layout := range listTimeLayouts {
+		var modified time.Time
+		if modified, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+			return modified, nil
+		}
+	}
+
+	return time.Time{}, err
+}
+
+// parseTest collects lines such as "ERROR: CRC Failed : a.txt" from the
+// output of "7z t".
+func parseTest(output string) []EntryError {
+	var failed []EntryError
+
+	for _, line := range strings.Split(output, "\n") {
+		match := testErrorPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+
+		err := errors.New(match[1]) //nolint:err113 // reason reported by 7z
+		if strings.HasPrefix(match[1], "CRC Failed") {
+			err = fmt.Errorf("%w: %s", ErrChecksum, match[1])
+		}
+
+		failed = append(failed, EntryError{Name: match[2], Err: err})
+	}
+
+	return failed
+}
+
+func nativeList(archive string) ([]Entry, error) {
+	r, err := OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	entries := make([]Entry, 0, len(r.File))
+	current := -1
+
+	for _, file := range r.File {
+		entry := Entry{
+			Path:       file.Name,
+			Size:       file.Size,
+			PackedSize: 0,
+			CRC32:      file.CRC32,
+			Attributes: attributeString(file),
+			Method:     "",
+			Encrypted:  false,
+			Modified:   file.Modified,
+			IsDir:      file.IsDir,
+		}
+
+		// Like 7z, the packed size of a block is reported on its first file.
+		if file.hasStream {
+			f := r.streams.folders[file.folder]
+			entry.Method, entry.Encrypted = methodString(f)
+
+			if file.folder != current {
+				for i := range f.packed {
+					entry.PackedSize += r.streams.packSizes[f.packStart+i]
+				}
+
+				current = file.folder
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// nativeTest decodes each folder once. A checksum error leaves the stream at
+// the next file, any other error fails the rest of the folder.
+func nativeTest(ctx context.Context, archive string) ([]EntryError, error) {
+	r, err := OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var failed []EntryError
+
+	var stream io.Reader
+
+	var broken error
+
+	current := -1
+
+	for _, file := range r.File {
+		if !file.hasStream {
+			continue
+		}
+
+		if file.folder != current {
+			current, broken = file.folder, nil
+
+			if stream, err = r.folderReader(r.streams, file.folder); err != nil {
+				broken = err
+			}
+
+			stream = contextReader{ctx: ctx, r: stream}
+		}
+
+		if broken == nil {
+			if _, err := io.Copy(io.Discard, newChecksumReader(stream, file)); ctx.Err() != nil {
+				return nil, ctx.Err()
+			} else if err != nil && !errors.Is(err, ErrChecksum) {
+				broken = err
+			} else if err != nil {
+				failed = append(failed, EntryError{Name: file.Name, Err: err})
+				continue
+			}
+		}
+
+		if broken != nil {
+			failed = append(failed, EntryError{Name: file.Name, Err: broken})
+		}
+	}
+
+	return failed, nil
+}
+
+func methodString(f *folder) (string, bool) {
+	names := make([]string, 0, len(f.coders))
+	encrypted := false
+
+	for _, c := range f.coders {
+		name, ok := methodNames[c.id]
+		if !ok {
+			name = fmt.Sprintf("%X", []byte(c.id))
+		}
+
+		encrypted = encrypted || c.id == methodAES
+		names = append(names, name)
+	}
+
+	return strings.Join(names, " "), encrypted
+}
+
+// attributeString renders attributes as 7z does, the Windows flags followed
+// by the unix mode when the archive stores one.
+func attributeString(file *File) string {
+	var b strings.Builder
+
+	for _, flag := range []struct {
+		bit  uint32
+		name byte
+	}{{attrReadOnly, 'R'}, {attrHidden, 'H'}, {attrSystem, 'S'}, {attrDirectory, 'D'}, {attrArchive, 'A'}} {
+		if file.Attributes&flag.bit != 0 || (flag.bit == attrDirectory && file.IsDir) {
+			b.WriteByte(flag.name)
+		}
+	}
+
+	if file.Attributes&attrUnixExtension != 0 {
+		mode := file.Mode()
+		if !file.IsDir {
+			mode &= fs.ModePerm
+		}
+
+		b.WriteString(" " + mode.String())
+	}
+
+	return strings.TrimSpace(b.String())
+}
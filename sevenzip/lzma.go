@@ -0,0 +1,786 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sevenzip
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// LZMA decoding follows the reference decoder in the LZMA SDK (LzmaSpec.cpp).
+const (
+	rcTopValue        = 1 << 24
+	rcBitModelBits    = 11
+	rcBitModelTotal   = 1 << rcBitModelBits
+	rcMoveBits        = 5
+	lzmaStates        = 12
+	lzmaLiteralStates = 7
+	lzmaPosBitsMax    = 4
+	lzmaLenToPosState = 4
+	lzmaPosSlotBits   = 6
+	lzmaAlignBits     = 4
+	lzmaStartPosModel = 4
+	lzmaEndPosModel   = 14
+	lzmaFullDistances = 1 << (lzmaEndPosModel >> 1)
+	lzmaLenLowBits    = 3
+	lzmaLenHighBits   = 8
+	lzmaLenLowCount   = 1 << lzmaLenLowBits
+	lzmaLiteralSize   = 0x300
+	lzmaMatchMinLen   = 2
+	lzmaMatchMaxLen   = 273
+	lzmaPropsSize     = 5
+	lzmaMinDictSize   = 1 << 12
+	lzmaEndMarker     = 0xFFFFFFFF
+	lzmaMaxLcLp       = 4
+	lzma2MaxDictProp  = 40
+	lzmaBufferSize    = 1 << 16
+)
+
+var (
+	errLZMAData  = errors.New("lzma: corrupt data")
+	errLZMAProps = errors.New("lzma: invalid properties")
+)
+
+type prob uint16
+
+func initProbs(probs []prob) {
+	for i := range probs {
+		probs[i] = rcBitModelTotal / 2
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// rangeDecoder keeps the first read error and returns zero bytes after it, so
+// that the bit decoding loops only need to check for an error once per step.
+type rangeDecoder struct {
+	br   io.ByteReader
+	rng  uint32
+	code uint32
+	err  error
+}
+
+func (rc *rangeDecoder) init(br io.ByteReader) error {
+	rc.br, rc.rng, rc.code, rc.err = br, lzmaEndMarker, 0, nil
+
+	first := rc.readByte()
+	for range 4 {
+		rc.code = rc.code<<8 | uint32(rc.readByte())
+	}
+
+	if rc.err != nil {
+		return rc.err
+	}
+
+	if first != 0 || rc.code == rc.rng {
+		return errLZMAData
+	}
+
+	return nil
+}
+
+func (rc *rangeDecoder) readByte() byte {
+	b, err := rc.br.ReadByte()
+	if err != nil && rc.err == nil {
+		rc.err = unexpectedEOF(err)
+	}
+
+	return b
+}
+
+func (rc *rangeDecoder) normalize() {
+	if rc.rng < rcTopValue {
+		rc.rng <<= 8
+		rc.code = rc.code<<8 | uint32(rc.readByte())
+	}
+}
+
+func (rc *rangeDecoder) bit(p *prob) uint32 {
+	v := uint32(*p)
+	bound := (rc.rng >> rcBitModelBits) * v
+
+	var symbol uint32
+
+	if rc.code < bound {
+		v += (rcBitModelTotal - v) >> rcMoveBits
+		rc.rng = bound
+	} else {
+		v -= v >> rcMoveBits
+		rc.code -= bound
+		rc.rng -= bound
+		symbol = 1
+	}
+
+	*p = prob(v)
+	rc.normalize()
+
+	return symbol
+}
+
+func (rc *rangeDecoder) direct(bits uint32) uint32 {
+	var res uint32
+
+	for ; bits > 0; bits-- {
+		rc.rng >>= 1
+		rc.code -= rc.rng
+		t := 0 - (rc.code >> 31)
+		rc.code += rc.rng & t
+		rc.normalize()
+		res = res<<1 + t + 1
+	}
+
+	return res
+}
+
+func (rc *rangeDecoder) tree(probs []prob, bits uint32) uint32 {
+	m := uint32(1)
+	for range bits {
+		m = m<<1 + rc.bit(&probs[m])
+	}
+
+	return m - 1<<bits
+}
+
+func (rc *rangeDecoder) reverseTree(probs []prob, bits uint32) uint32 {
+	m, symbol := uint32(1), uint32(0)
+
+	for i := range bits {
+		bit := rc.bit(&probs[m])
+		m = m<<1 + bit
+		symbol |= bit << i
+	}
+
+	return symbol
+}
+
+type lenDecoder struct {
+	choice  prob
+	choice2 prob
+	low     [1 << lzmaPosBitsMax][lzmaLenLowCount]prob
+	mid     [1 << lzmaPosBitsMax][lzmaLenLowCount]prob
+	high    [1 << lzmaLenHighBits]prob
+}
+
+func (d *lenDecoder) reset() {
+	d.choice, d.choice2 = rcBitModelTotal/2, rcBitModelTotal/2
+
+	for i := range d.low {
+		initProbs(d.low[i][:])
+		initProbs(d.mid[i][:])
+	}
+
+	initProbs(d.high[:])
+}
+
+func (d *lenDecoder) decode(rc *rangeDecoder, posState uint32) uint32 {
+	if rc.bit(&d.choice) == 0 {
+		return rc.tree(d.low[posState][:], lzmaLenLowBits)
+	}
+
+	if rc.bit(&d.choice2) == 0 {
+		return lzmaLenLowCount + rc.tree(d.mid[posState][:], lzmaLenLowBits)
+	}
+
+	return 2*lzmaLenLowCount + rc.tree(d.high[:], lzmaLenHighBits)
+}
+
+// window is the sliding dictionary. Decoded bytes stay pending in it until
+// they are read out, and decoding stops well before pending bytes could be
+// overwritten.
+type window struct {
+	buf     []byte
+	pos     int
+	total   uint64
+	pending int
+}
+
+func newWindow(dictSize uint32, outSize uint64) window {
+	size := max(min(uint64(dictSize), outSize), lzmaMinDictSize)
+	return window{buf: make([]byte, size), pos: 0, total: 0, pending: 0}
+}
+
+func (w *window) reset() {
+	w.pos, w.total = 0, 0
+}
+
+func (w *window) put(b byte) {
+	w.buf[w.pos] = b
+	w.pos++
+
+	if w.pos == len(w.buf) {
+		w.pos = 0
+	}
+
+	w.total++
+	w.pending++
+}
+
+func (w *window) get(dist uint32) byte {
+	i := w.pos - int(dist)
+	if i < 0 {
+		i += len(w.buf)
+	}
+
+	return w.buf[i]
+}
+
+func (w *window) hasDistance(dist uint32) bool {
+	return uint64(dist) <= w.total && int(dist) <= len(w.buf)
+}
+
+func (w *window) copyMatch(dist, length uint32) {
+	for range length {
+		w.put(w.get(dist))
+	}
+}
+
+// room is how many bytes may be decoded before reading, given a read of n.
+func (w *window) room(n int) int {
+	return min(n, len(w.buf)-lzmaMatchMaxLen)
+}
+
+func (w *window) read(p []byte) int {
+	n := min(len(p), w.pending)
+
+	start := w.pos - w.pending
+	if start < 0 {
+		start += len(w.buf)
+	}
+
+	if c := copy(p[:n], w.buf[start:]); c < n {
+		copy(p[c:n], w.buf)
+	}
+
+	w.pending -= n
+
+	return n
+}
+
+type lzmaDecoder struct {
+	lc, lp, pb uint32
+	dictSize   uint32
+	literal    []prob
+	posSlot    [lzmaLenToPosState][1 << lzmaPosSlotBits]prob
+	posSpecial [1 + lzmaFullDistances - lzmaEndPosModel]prob
+	align      [1 << lzmaAlignBits]prob
+	isMatch    [lzmaStates << lzmaPosBitsMax]prob
+	isRep      [lzmaStates]prob
+	isRepG0    [lzmaStates]prob
+	isRepG1    [lzmaStates]prob
+	isRepG2    [lzmaStates]prob
+	isRep0Long [lzmaStates << lzmaPosBitsMax]prob
+	length     lenDecoder
+	repLength  lenDecoder
+	state      uint32
+	rep        [4]uint32
+	rc         rangeDecoder
+	win        *window
+	eos        bool
+}
+
+func (d *lzmaDecoder) setProps(props byte) error {
+	if props >= 9*5*5 { //nolint:mnd // lc < 9, lp < 5, pb < 5
+		return errLZMAProps
+	}
+
+	d.lc, d.lp, d.pb = uint32(props%9), uint32(props/9%5), uint32(props/45) //nolint:mnd // see above
+
+	if size := lzmaLiteralSize << (d.lc + d.lp); len(d.literal) != size {
+		d.literal = make([]prob, size)
+	}
+
+	return nil
+}
+
+func (d *lzmaDecoder) reset() {
+	initProbs(d.literal)
+
+	for i := range d.posSlot {
+		initProbs(d.posSlot[i][:])
+	}
+
+	initProbs(d.posSpecial[:])
+	initProbs(d.align[:])
+	initProbs(d.isMatch[:])
+	initProbs(d.isRep[:])
+	initProbs(d.isRepG0[:])
+	initProbs(d.isRepG1[:])
+	initProbs(d.isRepG2[:])
+	initProbs(d.isRep0Long[:])
+	d.length.reset()
+	d.repLength.reset()
+
+	d.state, d.rep, d.eos = 0, [4]uint32{}, false
+}
+
+func (d *lzmaDecoder) literalByte() {
+	rc, w := &d.rc, d.win
+
+	var prev uint32
+	if w.total > 0 {
+		prev = uint32(w.get(1))
+	}
+
+	litState := (uint32(w.total)&(1<<d.lp-1))<<d.lc + prev>>(8-d.lc)
+	probs := d.literal[lzmaLiteralSize*litState:]
+	symbol := uint32(1)
+
+	if d.state >= lzmaLiteralStates {
+		match := uint32(w.get(d.rep[0] + 1))
+
+		for symbol < 0x100 {
+			matchBit := (match >> 7) & 1
+			match <<= 1
+			bit := rc.bit(&probs[(1+matchBit)<<8+symbol])
+			symbol = symbol<<1 | bit
+
+			if matchBit != bit {
+				break
+			}
+		}
+	}
+
+	for symbol < 0x100 {
+		symbol = symbol<<1 | rc.bit(&probs[symbol])
+	}
+
+	w.put(byte(symbol))
+}
+
+func (d *lzmaDecoder) distance(length uint32) uint32 {
+	rc := &d.rc
+
+	slot := rc.tree(d.posSlot[min(length, lzmaLenToPosState-1)][:], lzmaPosSlotBits)
+	if slot < lzmaStartPosModel {
+		return slot
+	}
+
+	direct := slot>>1 - 1
+	dist := (2 | slot&1) << direct
+
+	if slot < lzmaEndPosModel {
+		return dist + rc.reverseTree(d.posSpecial[dist-slot:], direct)
+	}
+
+	dist += rc.direct(direct-lzmaAlignBits) << lzmaAlignBits
+
+	return dist + rc.reverseTree(d.align[:], lzmaAlignBits)
+}
+
+// step decodes one literal or match of at most limit bytes.
+func (d *lzmaDecoder) step(limit uint64) error {
+	rc, w := &d.rc, d.win
+	posState := uint32(w.total) & (1<<d.pb - 1)
+	state := d.state
+
+	if rc.bit(&d.isMatch[state<<lzmaPosBitsMax+posState]) == 0 {
+		d.literalByte()
+		d.state = nextLiteralState(state)
+
+		return rc.err
+	}
+
+	var length uint32
+
+	if rc.bit(&d.isRep[state]) == 0 {
+		d.rep[3], d.rep[2], d.rep[1] = d.rep[2], d.rep[1], d.rep[0]
+		length = d.length.decode(rc, posState)
+		d.state = nextState(state, 7, 10) //nolint:mnd // match states
+		d.rep[0] = d.distance(length)
+
+		if d.rep[0] == lzmaEndMarker {
+			d.eos = true
+			return rc.err
+		}
+
+		if d.rep[0] >= d.dictSize {
+			return errLZMAData
+		}
+	} else {
+		if w.total == 0 {
+			return errLZMAData
+		}
+
+		if rc.bit(&d.isRepG0[state]) == 0 {
+			if rc.bit(&d.isRep0Long[state<<lzmaPosBitsMax+posState]) == 0 {
+				d.state = nextState(state, 9, 11) //nolint:mnd // short rep states
+				w.put(w.get(d.rep[0] + 1))
+
+				return rc.err
+			}
+		} else {
+			var dist uint32
+
+			if rc.bit(&d.isRepG1[state]) == 0 {
+				dist = d.rep[1]
+			} else {
+				if rc.bit(&d.isRepG2[state]) == 0 {
+					dist = d.rep[2]
+				} else {
+					dist = d.rep[3]
+					d.rep[3] = d.rep[2]
+				}
+
+				d.rep[2] = d.rep[1]
+			}
+
+			d.rep[1] = d.rep[0]
+			d.rep[0] = dist
+		}
+
+		length = d.repLength.decode(rc, posState)
+		d.state = nextState(state, 8, 11) //nolint:mnd // rep states
+	}
+
+	length += lzmaMatchMinLen
+
+	if rc.err != nil {
+		return rc.err
+	}
+
+	if uint64(length) > limit || !w.hasDistance(d.rep[0]+1) {
+		return errLZMAData
+	}
+
+	w.copyMatch(d.rep[0]+1, length)
+
+	return nil
+}
+
+func nextLiteralState(state uint32) uint32 {
+	switch {
+	case state < 4: //nolint:mnd // literal states
+		return 0
+	case state < 10: //nolint:mnd // literal states
+		return state - 3 //nolint:mnd // literal states
+	default:
+		return state - 6 //nolint:mnd // literal states
+	}
+}
+
+func nextState(state, short, long uint32) uint32 {
+	if state < lzmaLiteralStates {
+		return short
+	}
+
+	return long
+}
+
+// lzmaReader decodes an LZMA stream of known size, as stored in 7z folders.
+type lzmaReader struct {
+	dec       lzmaDecoder
+	win       window
+	remaining uint64
+	err       error
+}
+
+func newLZMAReader(r io.Reader, props []byte, size uint64) (io.Reader, error) {
+	if len(props) < lzmaPropsSize {
+		return nil, errLZMAProps
+	}
+
+	z := &lzmaReader{remaining: size} //nolint:exhaustruct // initialized below
+
+	if err := z.dec.setProps(props[0]); err != nil {
+		return nil, err
+	}
+
+	z.dec.dictSize = max(binary.LittleEndian.Uint32(props[1:]), lzmaMinDictSize)
+	z.win = newWindow(z.dec.dictSize, size)
+	z.dec.win = &z.win
+	z.dec.reset()
+
+	if size == 0 {
+		z.err = io.EOF
+		return z, nil
+	}
+
+	if err := z.dec.rc.init(bufio.NewReaderSize(r, lzmaBufferSize)); err != nil {
+		return nil, err
+	}
+
+	return z, nil
+}
+
+func (z *lzmaReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if z.win.pending == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+
+		z.err = z.fill(z.win.room(len(p)))
+	}
+
+	if n := z.win.read(p); n > 0 {
+		return n, nil
+	}
+
+	return 0, z.err
+}
+
+func (z *lzmaReader) fill(want int) error {
+	for z.win.pending < want {
+		if z.remaining == 0 {
+			return io.EOF
+		}
+
+		if z.dec.eos {
+			return io.ErrUnexpectedEOF
+		}
+
+		before := z.win.total
+
+		if err := z.dec.step(z.remaining); err != nil {
+			return err
+		}
+
+		z.remaining -= z.win.total - before
+	}
+
+	return nil
+}
+
+type countingReader struct {
+	r *bufio.Reader
+	n uint64
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+
+	return b, err
+}
+
+// lzma2Reader decodes an LZMA2 stream, a sequence of LZMA and uncompressed
+// chunks sharing one dictionary.
+type lzma2Reader struct {
+	src        *countingReader
+	dec        lzmaDecoder
+	win        window
+	chunkLeft  uint64
+	packed     uint64
+	compressed bool
+	needDict   bool
+	needProps  bool
+	err        error
+}
+
+func lzma2DictSize(prop byte) (uint32, error) {
+	if prop > lzma2MaxDictProp {
+		return 0, errLZMAProps
+	}
+
+	if prop == lzma2MaxDictProp {
+		return lzmaEndMarker, nil
+	}
+
+	return (2 | uint32(prop)&1) << (prop/2 + 11), nil //nolint:mnd // see the LZMA2 specification
+}
+
+func newLZMA2Reader(r io.Reader, props []byte, size uint64) (io.Reader, error) {
+	if len(props) < 1 {
+		return nil, errLZMAProps
+	}
+
+	dictSize, err := lzma2DictSize(props[0])
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:exhaustruct // decoder state is set up by the first chunk
+	z := &lzma2Reader{
+		src:       &countingReader{r: bufio.NewReaderSize(r, lzmaBufferSize), n: 0},
+		needDict:  true,
+		needProps: true,
+	}
+	z.dec.dictSize = max(dictSize, lzmaMinDictSize)
+	z.win = newWindow(z.dec.dictSize, size)
+	z.dec.win = &z.win
+
+	return z, nil
+}
+
+func (z *lzma2Reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if z.win.pending == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+
+		z.err = z.fill(z.win.room(len(p)))
+	}
+
+	if n := z.win.read(p); n > 0 {
+		return n, nil
+	}
+
+	return 0, z.err
+}
+
+func (z *lzma2Reader) fill(want int) error {
+	for z.win.pending < want {
+		if z.chunkLeft == 0 {
+			if z.compressed {
+				if z.dec.rc.code != 0 || z.src.n != z.packed {
+					return errLZMAData
+				}
+
+				z.compressed = false
+			}
+
+			// The next chunk may reset the dictionary, which must not happen
+			// while decoded bytes are still waiting to be read.
+			if z.win.pending > 0 {
+				return nil
+			}
+
+			if err := z.nextChunk(); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if !z.compressed {
+			b, err := z.src.ReadByte()
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+
+			z.win.put(b)
+			z.chunkLeft--
+
+			continue
+		}
+
+		before := z.win.total
+
+		if err := z.dec.step(z.chunkLeft); err != nil {
+			return err
+		}
+
+		if z.dec.eos {
+			return errLZMAData
+		}
+
+		z.chunkLeft -= z.win.total - before
+	}
+
+	return nil
+}
+
+func (z *lzma2Reader) nextChunk() error {
+	header := make([]byte, lzmaPropsSize+1)
+
+	control, err := z.src.ReadByte()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+
+	switch {
+	case control == 0:
+		return io.EOF
+	case control == 1 || control >= 0xE0:
+		z.win.reset()
+		z.needDict = false
+	case z.needDict:
+		return errLZMAData
+	}
+
+	if control < 0x80 {
+		if control > 2 { //nolint:mnd // 1 and 2 are the uncompressed chunk types
+			return errLZMAData
+		}
+
+		if err := z.readFull(header[:2]); err != nil {
+			return err
+		}
+
+		z.chunkLeft = uint64(binary.BigEndian.Uint16(header)) + 1
+		z.compressed = false
+
+		return nil
+	}
+
+	size := 4
+	if control >= 0xC0 {
+		size++
+	}
+
+	if err := z.readFull(header[:size]); err != nil {
+		return err
+	}
+
+	z.chunkLeft = uint64(control&0x1F)<<16 + uint64(binary.BigEndian.Uint16(header)) + 1
+	z.packed = uint64(binary.BigEndian.Uint16(header[2:])) + 1
+
+	if control >= 0xC0 {
+		if err := z.dec.setProps(header[4]); err != nil {
+			return err
+		}
+
+		if z.dec.lc+z.dec.lp > lzmaMaxLcLp {
+			return errLZMAProps
+		}
+
+		z.needProps = false
+	} else if z.needProps {
+		return errLZMAData
+	}
+
+	if control >= 0xA0 {
+		z.dec.reset()
+	}
+
+	z.src.n = 0
+	z.compressed = true
+
+	return z.dec.rc.init(z.src)
+}
+
+func (z *lzma2Reader) readFull(p []byte) error {
+	for i := range p {
+		b, err := z.src.ReadByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		p[i] = b
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sevenzip
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ricochhet/minicommon/charmbracelet"
+)
+
+const fullProgress = 100
+
+//nolint:gochecknoglobals // wontfix
+var (
+	progressPattern = regexp.MustCompile(`^(\d+)%`)
+	fileLinePattern = regexp.MustCompile(`^[-+TU=] (.+)$`)
+)
+
+type Messenger struct {
+	Progress func(int)
+	File     func(string)
+}
+
+func DefaultSzMessenger() Messenger {
+	return Messenger{
+		Progress: func(percent int) {
+			charmbracelet.SharedLogger.Debugf("7z progress: %d%%", percent)
+		},
+		File: func(name string) {
+			charmbracelet.SharedLogger.Infof("7z: %s", name)
+		},
+	}
+}
+
+func messengerFor(silent bool) Messenger {
+	if silent {
+		return Messenger{Progress: nil, File: nil}
+	}
+
+	return DefaultSzMessenger()
+}
+
+func (m Messenger) progress(percent int) {
+	if m.Progress != nil {
+		m.Progress(percent)
+	}
+}
+
+func (m Messenger) file(name string) {
+	if m.File != nil {
+		m.File(name)
+	}
+}
+
+// outputParser reads the output of 7z run with -bsp1 -bb1. The progress line
+// is redrawn in place with backspaces and carriage returns, while processed
+// files are logged on lines of their own such as "- dir/name".
+type outputParser struct {
+	*progressReporter
+	line []byte
+}
+
+func newOutputParser(messenger Messenger) *outputParser {
+	return &outputParser{progressReporter: newProgressReporter(messenger), line: nil}
+}
+
+func (p *outputParser) Write(b []byte) (int, error) {
+	for _, c := range b {
+		switch c {
+		case '\b', '\r':
+			p.flush(false)
+		case '\n':
+			p.flush(true)
+		default:
+			p.line = append(p.line, c)
+		}
+	}
+
+	return len(b), nil
+}
+
+func (p *outputParser) flush(complete bool) {
+	line := strings.TrimSpace(string(p.line))
+	p.line = p.line[:0]
+
+	if match := progressPattern.FindStringSubmatch(line); match != nil {
+		if percent, err := strconv.Atoi(match[1]); err == nil {
+			p.report(percent)
+		}
+
+		return
+	}
+
+	if match := fileLinePattern.FindStringSubmatch(line); complete && match != nil {
+		p.messenger.file(match[1])
+	}
+}
+
+// finish reports completion, which 7z does not always print.
+func (p *outputParser) finish() {
+	p.flush(true)
+	p.report(fullProgress)
+}
+
+// progressReporter forwards each percentage to the messenger once.
+type progressReporter struct {
+	messenger Messenger
+	percent   int
+}
+
+func newProgressReporter(messenger Messenger) *progressReporter {
+	return &progressReporter{messenger: messenger, percent: -1}
+}
+
+func (p *progressReporter) report(percent int) {
+	if percent != p.percent {
+		p.percent = percent
+		p.messenger.progress(percent)
+	}
+}
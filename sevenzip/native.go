@@ -0,0 +1,209 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sevenzip
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ricochhet/minicommon/filesystem"
+)
+
+// NativeExtract extracts the archive at src into dest without the 7z binary.
+// It supports the Copy, LZMA, LZMA2, BCJ and BCJ2 coders.
+func NativeExtract(src, dest string) error {
+	return NativeExtractWithMessenger(src, dest, DefaultSzMessenger())
+}
+
+func NativeExtractWithMessenger(src, dest string, messenger Messenger) error {
+	return NativeExtractWithContext(context.TODO(), messenger, src, dest)
+}
+
+// NativeExtractWithContext stops between files and between reads once ctx is
+// done and returns the context's error.
+func NativeExtractWithContext(ctx context.Context, messenger Messenger, src, dest string) error {
+	//nolint:exhaustruct // every file, overwriting
+	return nativeExtract(ctx, messenger, src, dest, ExtractOptions{})
+}
+
+// NestedFormat lets the zip package extract 7z archives nested in zip
+// archives, decoding them natively so that its limits apply as they are
+// written, see zip.Options.
+type NestedFormat struct{}
+
+func (NestedFormat) Match(magic []byte) bool {
+	return bytes.HasPrefix(magic, signature)
+}
+
+func (NestedFormat) Walk(path string, fn func(name string, info fs.FileInfo, contents io.Reader) error) error {
+	archive, err := OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	return archive.Walk(func(file *File, contents io.Reader) error {
+		return fn(file.Name, file.FileInfo(), contents)
+	})
+}
+
+func nativeExtract(ctx context.Context, messenger Messenger, src, dest string, opt ExtractOptions) error {
+	archive, err := OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	return archive.extract(ctx, dest, messenger, opt)
+}
+
+// extract decodes each folder once, in order, so solid archives are not
+// decompressed again for every file they contain. Files that are not
+// selected are skipped over in the decoded stream.
+//
+//nolint:gocognit,gocyclo,cyclop // wontfix
+func (r *Reader) extract(ctx context.Context, dest string, messenger Messenger, opt ExtractOptions) error {
+	var stream io.Reader
+
+	var total, done, pos uint64
+
+	for _, file := range r.File {
+		if opt.selected(file.Name) {
+			total += file.Size
+		}
+	}
+
+	progress := newProgressReporter(messenger)
+	current := -1
+
+	for _, file := range r.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if !opt.selected(file.Name) {
+			continue
+		}
+
+		path, err := filesystem.SecureJoin(dest, file.Name)
+		if err != nil {
+			return err
+		}
+
+		if file.IsDir {
+			if err := os.MkdirAll(path, os.ModePerm); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return err
+		}
+
+		path, ok, err := opt.Overwrite.target(path)
+		if err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		var src io.Reader = strings.NewReader("")
+
+		if file.hasStream {
+			if file.folder != current {
+				if stream, err = r.folderReader(r.streams, file.folder); err != nil {
+					return err
+				}
+
+				current, pos = file.folder, 0
+			}
+
+			if _, err := io.CopyN(io.Discard, stream, int64(file.offset-pos)); err != nil { //nolint:gosec // offsets fit the folder
+				return unexpectedEOF(err)
+			}
+
+			pos = file.offset + file.Size
+			src = newChecksumReader(stream, file)
+		}
+
+		src = contextReader{ctx: ctx, r: src}
+
+		if err := writeFile(path, src, file.Mode().Perm()); err != nil {
+			return err
+		}
+
+		messenger.file(file.Name)
+
+		if done += file.Size; total > 0 {
+			progress.report(int(done * fullProgress / total)) //nolint:gosec // at most 100
+		}
+
+		if !file.Modified.IsZero() {
+			if err := os.Chtimes(path, file.Modified, file.Modified); err != nil {
+				return err
+			}
+		}
+	}
+
+	progress.report(fullProgress)
+
+	return nil
+}
+
+// contextReader fails reads once its context is done.
+type contextReader struct {
+	ctx context.Context //nolint:containedctx // scoped to one extraction
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return c.r.Read(p)
+}
+
+func writeFile(path string, src io.Reader, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, src); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// archiveDir mirrors the "-o dest/*" form passed to 7z, which extracts into
+// a directory named after the archive.
+func archiveDir(src, dest string) string {
+	name := filepath.Base(src)
+	return filepath.Join(dest, strings.TrimSuffix(name, filepath.Ext(name)))
+}
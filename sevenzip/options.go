@@ -18,27 +18,107 @@
 
 package sevenzip
 
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"strconv"
+)
+
+const (
+	minDictionarySize     = 1 << 16
+	maxDictionarySize     = 1536 << 20
+	defaultDictionarySize = 64 << 20
+	minFastBytes          = 5
+	maxFastBytes          = 273
+	maxLevel              = 9
+	lzmaMemoryFactor      = 12
+	megabyte              = 1 << 20
+	defaultMemoryFraction = 0.5
+)
+
+var ErrInvalidOptions = errors.New("invalid 7z options")
+
+type Method int
+
+const (
+	LZMA2 Method = iota
+	LZMA
+	PPMd
+	BZip2
+	Deflate
+	Copy
+)
+
+//nolint:gochecknoglobals // wontfix
+var (
+	methodArgs = map[Method]string{
+		LZMA2:   "LZMA2",
+		LZMA:    "LZMA",
+		PPMd:    "PPMd",
+		BZip2:   "BZip2",
+		Deflate: "Deflate",
+		Copy:    "Copy",
+	}
+	formatMethods = map[string][]Method{
+		"7z":  {LZMA2, LZMA, PPMd, BZip2, Deflate, Copy},
+		"zip": {Deflate, LZMA, PPMd, BZip2, Copy},
+	}
+)
+
+func (m Method) String() string {
+	if name, ok := methodArgs[m]; ok {
+		return name
+	}
+
+	return "Method(" + strconv.Itoa(int(m)) + ")"
+}
+
+// Options configures compression. Sizes are in bytes, and a zero Level,
+// DictionarySize, FastBytes, Threads or MemoryFraction leaves the choice to
+// 7z; the Copy method stores files without compressing them. SolidBlockSize
+// only applies to 7z archives, where zero disables solid compression.
+// MemoryFraction limits 7z to that part of the host's RAM and VolumeSize
+// splits the archive into volumes of at most that size.
+//
+// Password is passed on the command line of 7z, where other users of the
+// machine can read it from the process list while 7z runs.
 type Options struct {
-	SzCompressionFormat         string
-	SzCompressionLevel          string
-	SzCompressionMethod         string
-	SzCompressionDictionarySize string
-	SzCompressionFastBytes      string
-	SzCompressionSolidBlockSize string
-	SzCompressionMultithreading string
-	SzCompressionMemory         string
+	Format         string
+	Level          int
+	Method         Method
+	DictionarySize uint64
+	FastBytes      int
+	SolidBlockSize uint64
+	Threads        int
+	MemoryFraction float64
+	VolumeSize     uint64
+	Password       string
+	EncryptHeaders bool
+}
+
+// DefaultOptions returns the options SzCompress uses when none are given,
+// sized for the host's CPU and memory.
+func DefaultOptions() Options {
+	return getDefaultOptions()
 }
 
 func getDefaultOptions() Options {
+	threads := runtime.NumCPU()
+
 	return Options{
-		SzCompressionFormat:         "7z",
-		SzCompressionLevel:          "-mx9",
-		SzCompressionMethod:         "-m0=lzma2",
-		SzCompressionDictionarySize: "-md=64m",
-		SzCompressionFastBytes:      "-mfb=64",
-		SzCompressionSolidBlockSize: "-ms=4g",
-		SzCompressionMultithreading: "-mmt=2",
-		SzCompressionMemory:         "-mmemuse=26g",
+		Format:         "7z",
+		Level:          maxLevel,
+		Method:         LZMA2,
+		DictionarySize: dictionarySizeFor(totalMemory(), defaultMemoryFraction, threads),
+		FastBytes:      64,      //nolint:mnd // 7z's -mx9 default
+		SolidBlockSize: 4 << 30, //nolint:mnd // 4 GiB
+		Threads:        threads,
+		MemoryFraction: defaultMemoryFraction,
+		VolumeSize:     0,
+		Password:       "",
+		EncryptHeaders: false,
 	}
 }
 
@@ -51,3 +131,115 @@ func assureOptions(opts ...Options) Options {
 
 	return opts[0]
 }
+
+// dictionarySizeFor picks the largest power of two up to 64 MiB whose LZMA2
+// match finders, roughly 12 times the dictionary for every two threads, fit
+// in the memory limit. Without a known amount of memory it returns 64 MiB.
+func dictionarySizeFor(memory uint64, fraction float64, threads int) uint64 {
+	size := uint64(defaultDictionarySize)
+	if memory == 0 {
+		return size
+	}
+
+	limit := uint64(float64(memory) * fraction)
+	encoders := uint64(max(1, threads/2)) //nolint:gosec // threads is positive
+
+	for size > megabyte && size*lzmaMemoryFactor*encoders > limit {
+		size /= 2
+	}
+
+	return size
+}
+
+func (o Options) format() string {
+	if o.Format == "" {
+		return "7z"
+	}
+
+	return o.Format
+}
+
+func (o Options) Validate() error {
+	methods, ok := formatMethods[o.format()]
+	if !ok {
+		return fmt.Errorf("%w: unsupported format %q", ErrInvalidOptions, o.Format)
+	}
+
+	switch {
+	case o.Level < 0 || o.Level > maxLevel:
+		return fmt.Errorf("%w: level %d is outside 0-9", ErrInvalidOptions, o.Level)
+	case !slices.Contains(methods, o.Method):
+		return fmt.Errorf("%w: %s does not support %s", ErrInvalidOptions, o.format(), o.Method)
+	case o.DictionarySize != 0 && (o.DictionarySize < minDictionarySize || o.DictionarySize > maxDictionarySize):
+		return fmt.Errorf("%w: dictionary size %d is outside 64 KiB-1.5 GiB", ErrInvalidOptions, o.DictionarySize)
+	case o.FastBytes != 0 && (o.FastBytes < minFastBytes || o.FastBytes > maxFastBytes):
+		return fmt.Errorf("%w: fast bytes %d is outside 5-273", ErrInvalidOptions, o.FastBytes)
+	case o.Threads < 0:
+		return fmt.Errorf("%w: negative thread count", ErrInvalidOptions)
+	case o.MemoryFraction < 0 || o.MemoryFraction > 1:
+		return fmt.Errorf("%w: memory fraction %g is outside 0-1", ErrInvalidOptions, o.MemoryFraction)
+	case o.EncryptHeaders && (o.Password == "" || o.format() != "7z"):
+		return fmt.Errorf("%w: header encryption needs a password and the 7z format", ErrInvalidOptions)
+	}
+
+	return nil
+}
+
+// Args validates the options and renders them as 7z switches.
+func (o Options) Args() ([]string, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	args := []string{"-t" + o.format()}
+
+	if o.Level != 0 {
+		args = append(args, "-mx="+strconv.Itoa(o.Level))
+	}
+
+	if o.format() == "7z" {
+		args = append(args, "-m0="+o.Method.String())
+	} else {
+		args = append(args, "-mm="+o.Method.String())
+	}
+
+	if o.Method == LZMA2 || o.Method == LZMA {
+		if o.DictionarySize != 0 {
+			args = append(args, "-md="+strconv.FormatUint(o.DictionarySize, 10)+"b")
+		}
+
+		if o.FastBytes != 0 {
+			args = append(args, "-mfb="+strconv.Itoa(o.FastBytes))
+		}
+	}
+
+	if o.format() == "7z" {
+		if o.SolidBlockSize == 0 {
+			args = append(args, "-ms=off")
+		} else {
+			args = append(args, "-ms="+strconv.FormatUint(o.SolidBlockSize, 10)+"b")
+		}
+	}
+
+	if o.Threads != 0 {
+		args = append(args, "-mmt="+strconv.Itoa(o.Threads))
+	}
+
+	if memory := totalMemory(); o.MemoryFraction != 0 && memory != 0 {
+		args = append(args, "-mmemuse="+strconv.FormatUint(uint64(float64(memory)*o.MemoryFraction)/megabyte, 10)+"m")
+	}
+
+	if o.VolumeSize != 0 {
+		args = append(args, "-v"+strconv.FormatUint(o.VolumeSize, 10)+"b")
+	}
+
+	if o.Password != "" {
+		args = append(args, "-p"+o.Password)
+	}
+
+	if o.EncryptHeaders {
+		args = append(args, "-mhe=on")
+	}
+
+	return args, nil
+}
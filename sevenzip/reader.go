@@ -0,0 +1,1118 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sevenzip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Property IDs of the 7z header, see 7zFormat.txt in the 7-Zip sources.
+const (
+	idEnd = iota
+	idHeader
+	idArchiveProperties
+	idAdditionalStreamsInfo
+	idMainStreamsInfo
+	idFilesInfo
+	idPackInfo
+	idUnpackInfo
+	idSubStreamsInfo
+	idSize
+	idCRC
+	idFolder
+	idCodersUnpackSize
+	idNumUnpackStream
+	idEmptyStream
+	idEmptyFile
+	idAnti
+	idName
+	idCTime
+	idATime
+	idMTime
+	idWinAttributes
+	idComment
+	idEncodedHeader
+)
+
+const (
+	methodCopy  = "\x00"
+	methodLZMA  = "\x03\x01\x01"
+	methodLZMA2 = "\x21"
+	methodBCJ   = "\x03\x03\x01\x03"
+	methodBCJ2  = "\x03\x03\x01\x1b"
+	methodAES   = "\x06\xf1\x07\x01"
+)
+
+const (
+	signatureHeaderSize = 32
+	maxHeaderSize       = 1 << 28
+	coderIDSizeMask     = 0x0F
+	coderComplex        = 0x10
+	coderHasProps       = 0x20
+	coderAlternatives   = 0x80
+	attrDirectory       = 0x10
+	attrUnixExtension   = 0x8000
+	filetimeUnixOffset  = 11644473600
+	filetimeSecond      = 10000000
+)
+
+// maxHeaderDepth bounds how often a header may be encoded in another.
+const maxHeaderDepth = 4
+
+//nolint:gochecknoglobals // wontfix
+var signature = []byte{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C}
+
+type File struct {
+	Name       string
+	Size       uint64
+	CRC32      uint32
+	Modified   time.Time
+	Attributes uint32
+	IsDir      bool
+	hasCRC     bool
+	hasStream  bool
+	folder     int
+	offset     uint64
+	reader     *Reader
+}
+
+func (f *File) Mode() fs.FileMode {
+	mode := fs.FileMode(0o644)
+	if f.IsDir {
+		mode = fs.ModeDir | 0o755 //nolint:mnd // default directory permissions
+	}
+
+	if f.Attributes&attrUnixExtension != 0 {
+		return mode.Type() | fs.FileMode(f.Attributes>>16)&fs.ModePerm
+	}
+
+	return mode
+}
+
+// Open returns a reader for the file's contents. Entries of a solid block
+// share one stream, so opening a file decodes everything stored before it.
+func (f *File) Open() (io.ReadCloser, error) {
+	if !f.hasStream {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	stream, err := f.reader.folderReader(f.reader.streams, f.folder)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.CopyN(io.Discard, stream, int64(f.offset)); err != nil { //nolint:gosec // offsets fit the folder
+		return nil, unexpectedEOF(err)
+	}
+
+	return io.NopCloser(newChecksumReader(stream, f)), nil
+}
+
+// FileInfo describes the file as an fs.FileInfo, whose Sys is the *File.
+func (f *File) FileInfo() fs.FileInfo {
+	return fileInfo{f: f}
+}
+
+type fileInfo struct {
+	f *File
+}
+
+func (fi fileInfo) Name() string       { return path.Base(fi.f.Name) }
+func (fi fileInfo) Size() int64        { return int64(fi.f.Size) } //nolint:gosec // sizes fit
+func (fi fileInfo) Mode() fs.FileMode  { return fi.f.Mode() }
+func (fi fileInfo) ModTime() time.Time { return fi.f.Modified }
+func (fi fileInfo) IsDir() bool        { return fi.f.IsDir }
+func (fi fileInfo) Sys() any           { return fi.f }
+
+type Reader struct {
+	File    []*File
+	r       io.ReaderAt
+	streams *streamsInfo
+}
+
+type ReadCloser struct {
+	Reader
+	file *os.File
+}
+
+func (r *ReadCloser) Close() error {
+	return r.file.Close()
+}
+
+func OpenReader(name string) (*ReadCloser, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	reader, err := NewReader(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &ReadCloser{Reader: *reader, file: file}, nil
+}
+
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	start := make([]byte, signatureHeaderSize)
+	if _, err := r.ReadAt(start, 0); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errNotSevenZip
+		}
+
+		return nil, err
+	}
+
+	if !bytes.Equal(start[:len(signature)], signature) {
+		return nil, errNotSevenZip
+	}
+
+	if crc32.ChecksumIEEE(start[12:]) != binary.LittleEndian.Uint32(start[8:]) {
+		return nil, errCorruptHeader
+	}
+
+	offset := binary.LittleEndian.Uint64(start[12:])
+	length := binary.LittleEndian.Uint64(start[20:])
+	reader := &Reader{File: nil, r: r, streams: nil}
+
+	if length == 0 {
+		return reader, nil
+	}
+
+	available := uint64(size) - signatureHeaderSize //nolint:gosec // size is at least the signature header here
+	if length > maxHeaderSize || offset > available || length > available-offset {
+		return nil, errCorruptHeader
+	}
+
+	header := make([]byte, length)
+	if _, err := r.ReadAt(header, int64(signatureHeaderSize+offset)); err != nil { //nolint:gosec // checked above
+		return nil, err
+	}
+
+	if crc32.ChecksumIEEE(header) != binary.LittleEndian.Uint32(start[28:]) {
+		return nil, errCorruptHeader
+	}
+
+	for range maxHeaderDepth {
+		h := &headerReader{buf: header, err: nil}
+
+		switch h.byte() {
+		case idHeader:
+			if err := reader.header(h); err != nil {
+				return nil, err
+			}
+
+			return reader, nil
+		case idEncodedHeader:
+			var err error
+
+			if header, err = reader.decodeHeader(h); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errCorruptHeader
+		}
+	}
+
+	return nil, errCorruptHeader
+}
+
+// Walk calls fn for every file in the archive, in order, with a reader for
+// its contents that is only valid until fn returns. Unlike opening each file,
+// it decodes every folder once.
+func (r *Reader) Walk(fn func(file *File, contents io.Reader) error) error {
+	var stream io.Reader
+
+	var pos uint64
+
+	current := -1
+
+	for _, file := range r.File {
+		var src io.Reader = bytes.NewReader(nil)
+
+		if file.hasStream {
+			if file.folder != current {
+				var err error
+
+				if stream, err = r.folderReader(r.streams, file.folder); err != nil {
+					return err
+				}
+
+				current, pos = file.folder, 0
+			}
+
+			if _, err := io.CopyN(io.Discard, stream, int64(file.offset-pos)); err != nil { //nolint:gosec // offsets fit the folder
+				return unexpectedEOF(err)
+			}
+
+			pos = file.offset + file.Size
+			src = newChecksumReader(stream, file)
+		}
+
+		if err := fn(file, src); err != nil {
+			return err
+		}
+
+		// Whatever fn left unread is skipped, which also verifies it.
+		if _, err := io.Copy(io.Discard, src); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeHeader unpacks a header that was itself compressed into a folder.
+func (r *Reader) decodeHeader(h *headerReader) ([]byte, error) {
+	streams := h.streamsInfo()
+	if h.err != nil {
+		return nil, h.err
+	}
+
+	if len(streams.folders) == 0 {
+		return nil, errCorruptHeader
+	}
+
+	folder := streams.folders[0]
+
+	size := folder.unpackSize()
+	if size > maxHeaderSize {
+		return nil, errCorruptHeader
+	}
+
+	stream, err := r.folderReader(streams, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, size)
+	if _, err := io.ReadFull(stream, header); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	if folder.hasCRC && crc32.ChecksumIEEE(header) != folder.crc {
+		return nil, fmt.Errorf("%w: header", ErrChecksum)
+	}
+
+	return header, nil
+}
+
+func (r *Reader) header(h *headerReader) error {
+	id := h.byte()
+
+	if id == idArchiveProperties {
+		for h.err == nil && h.byte() != idEnd {
+			h.skip()
+		}
+
+		id = h.byte()
+	}
+
+	if id == idAdditionalStreamsInfo {
+		h.streamsInfo()
+		id = h.byte()
+	}
+
+	if id == idMainStreamsInfo {
+		r.streams = h.streamsInfo()
+		id = h.byte()
+	}
+
+	if id == idFilesInfo {
+		h.filesInfo(r)
+		id = h.byte()
+	}
+
+	if h.err == nil && id != idEnd {
+		return errCorruptHeader
+	}
+
+	return h.err
+}
+
+func (r *Reader) folderReader(streams *streamsInfo, index int) (io.Reader, error) {
+	if streams == nil || index >= len(streams.folders) {
+		return nil, errCorruptHeader
+	}
+
+	folder := streams.folders[index]
+
+	return r.outStream(streams, folder, folder.mainOut(), len(folder.coders))
+}
+
+// outStream builds the decoder producing the folder's out stream by wiring
+// each coder input either to another coder's output or to a packed stream.
+func (r *Reader) outStream(streams *streamsInfo, f *folder, out, depth int) (io.Reader, error) {
+	if depth <= 0 || out < 0 {
+		return nil, errCorruptHeader
+	}
+
+	index, firstIn := f.coderOf(out)
+	if index < 0 {
+		return nil, errCorruptHeader
+	}
+
+	c := f.coders[index]
+	inputs := make([]io.Reader, c.numIn)
+
+	for i := range inputs {
+		if pair := f.bindPairForIn(firstIn + i); pair >= 0 {
+			input, err := r.outStream(streams, f, f.bindPairs[pair].out, depth-1)
+			if err != nil {
+				return nil, err
+			}
+
+			inputs[i] = input
+
+			continue
+		}
+
+		packed := f.packedIndex(firstIn + i)
+		if packed < 0 {
+			return nil, errCorruptHeader
+		}
+
+		pack := f.packStart + packed
+		//nolint:gosec // offsets were checked against the pack sizes
+		inputs[i] = io.NewSectionReader(r.r, int64(streams.packOffsets[pack]), int64(streams.packSizes[pack]))
+	}
+
+	return newCoderReader(c, inputs, f.unpackSizes[out])
+}
+
+func newCoderReader(c coder, inputs []io.Reader, size uint64) (io.Reader, error) {
+	if c.id == methodAES {
+		return nil, fmt.Errorf("%w: encrypted archives are not supported", ErrUnsupportedMethod)
+	}
+
+	wantIn := 1
+	if c.id == methodBCJ2 {
+		wantIn = bcj2Streams
+	}
+
+	if c.numOut != 1 || len(inputs) != wantIn {
+		return nil, fmt.Errorf("%w: %x", ErrUnsupportedMethod, []byte(c.id))
+	}
+
+	switch c.id {
+	case methodCopy:
+		return io.LimitReader(inputs[0], int64(size)), nil //nolint:gosec // sizes fit the archive
+	case methodLZMA:
+		return newLZMAReader(inputs[0], c.props, size)
+	case methodLZMA2:
+		return newLZMA2Reader(inputs[0], c.props, size)
+	case methodBCJ:
+		return newBCJReader(inputs[0], c.props), nil
+	case methodBCJ2:
+		return newBCJ2Reader(inputs, size)
+	}
+
+	return nil, fmt.Errorf("%w: %x", ErrUnsupportedMethod, []byte(c.id))
+}
+
+type checksumReader struct {
+	r    io.Reader
+	hash hash.Hash32
+	file *File
+	read uint64
+}
+
+func newChecksumReader(stream io.Reader, file *File) *checksumReader {
+	return &checksumReader{
+		r:    io.LimitReader(stream, int64(file.Size)), //nolint:gosec // sizes fit the folder
+		hash: crc32.NewIEEE(),
+		file: file,
+		read: 0,
+	}
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	c.read += uint64(n) //nolint:gosec // n is never negative
+
+	if errors.Is(err, io.EOF) {
+		if c.read != c.file.Size {
+			return n, io.ErrUnexpectedEOF
+		}
+
+		if c.file.hasCRC && c.hash.Sum32() != c.file.CRC32 {
+			return n, fmt.Errorf("%w: %s", ErrChecksum, c.file.Name)
+		}
+	}
+
+	return n, err
+}
+
+type coder struct {
+	id     string
+	numIn  int
+	numOut int
+	props  []byte
+}
+
+type bindPair struct {
+	in  int
+	out int
+}
+
+// folder is a graph of coders whose main output is one or more files, more
+// than one for solid archives.
+type folder struct {
+	coders      []coder
+	bindPairs   []bindPair
+	packed      []int
+	unpackSizes []uint64
+	crc         uint32
+	hasCRC      bool
+	packStart   int
+	streams     int
+	sizes       []uint64
+	crcs        []uint32
+	hasCRCs     []bool
+}
+
+func (f *folder) mainOut() int {
+	for out := range f.unpackSizes {
+		bound := false
+
+		for _, pair := range f.bindPairs {
+			if pair.out == out {
+				bound = true
+				break
+			}
+		}
+
+		if !bound {
+			return out
+		}
+	}
+
+	return -1
+}
+
+func (f *folder) unpackSize() uint64 {
+	if out := f.mainOut(); out >= 0 {
+		return f.unpackSizes[out]
+	}
+
+	return 0
+}
+
+func (f *folder) coderOf(out int) (int, int) {
+	firstIn, firstOut := 0, 0
+
+	for i, c := range f.coders {
+		if out < firstOut+c.numOut {
+			return i, firstIn
+		}
+
+		firstIn += c.numIn
+		firstOut += c.numOut
+	}
+
+	return -1, -1
+}
+
+func (f *folder) bindPairForIn(in int) int {
+	for i, pair := range f.bindPairs {
+		if pair.in == in {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (f *folder) packedIndex(in int) int {
+	for i, packed := range f.packed {
+		if packed == in {
+			return i
+		}
+	}
+
+	return -1
+}
+
+type streamsInfo struct {
+	packPos     uint64
+	packSizes   []uint64
+	packOffsets []uint64
+	folders     []*folder
+}
+
+// headerReader keeps the first error and returns zero values after it.
+type headerReader struct {
+	buf []byte
+	err error
+}
+
+func (h *headerReader) fail() {
+	if h.err == nil {
+		h.err = errCorruptHeader
+	}
+}
+
+func (h *headerReader) byte() byte {
+	if len(h.buf) == 0 {
+		h.fail()
+		return 0
+	}
+
+	b := h.buf[0]
+	h.buf = h.buf[1:]
+
+	return b
+}
+
+func (h *headerReader) bytes(n uint64) []byte {
+	if n > uint64(len(h.buf)) {
+		h.fail()
+		return nil
+	}
+
+	b := h.buf[:n]
+	h.buf = h.buf[n:]
+
+	return b
+}
+
+func (h *headerReader) skip() {
+	h.bytes(h.number())
+}
+
+func (h *headerReader) number() uint64 {
+	first := h.byte()
+
+	var value uint64
+
+	for i := range 8 {
+		mask := byte(0x80) >> i
+		if first&mask == 0 {
+			return value | uint64(first&(mask-1))<<(8*i)
+		}
+
+		value |= uint64(h.byte()) << (8 * i)
+	}
+
+	return value
+}
+
+// count reads a number of items, each of which takes at least one byte of
+// the remaining header.
+func (h *headerReader) count() int {
+	n := h.number()
+	if n > uint64(len(h.buf)) {
+		h.fail()
+		return 0
+	}
+
+	return int(n)
+}
+
+func (h *headerReader) uint32() uint32 {
+	if b := h.bytes(4); b != nil { //nolint:mnd // size of uint32
+		return binary.LittleEndian.Uint32(b)
+	}
+
+	return 0
+}
+
+func (h *headerReader) uint64() uint64 {
+	if b := h.bytes(8); b != nil { //nolint:mnd // size of uint64
+		return binary.LittleEndian.Uint64(b)
+	}
+
+	return 0
+}
+
+func (h *headerReader) bits(n int) []bool {
+	bits := make([]bool, n)
+
+	var b, mask byte
+
+	for i := range bits {
+		if mask == 0 {
+			b, mask = h.byte(), 0x80
+		}
+
+		bits[i] = b&mask != 0
+		mask >>= 1
+	}
+
+	return bits
+}
+
+func (h *headerReader) defined(n int) []bool {
+	if h.byte() == 0 {
+		return h.bits(n)
+	}
+
+	bits := make([]bool, n)
+	for i := range bits {
+		bits[i] = true
+	}
+
+	return bits
+}
+
+func (h *headerReader) digests(n int) ([]uint32, []bool) {
+	defined := h.defined(n)
+	crcs := make([]uint32, n)
+
+	for i := range crcs {
+		if defined[i] {
+			crcs[i] = h.uint32()
+		}
+	}
+
+	return crcs, defined
+}
+
+func (h *headerReader) streamsInfo() *streamsInfo {
+	//nolint:exhaustruct // filled in from the properties present
+	s := &streamsInfo{}
+	subStreams := false
+
+	for h.err == nil {
+		switch h.byte() {
+		case idEnd:
+			if !subStreams {
+				defaultSubStreams(s.folders)
+			}
+
+			h.locatePacks(s)
+
+			return s
+		case idPackInfo:
+			h.packInfo(s)
+		case idUnpackInfo:
+			h.unpackInfo(s)
+		case idSubStreamsInfo:
+			h.subStreamsInfo(s.folders)
+			subStreams = true
+		default:
+			h.fail()
+		}
+	}
+
+	return s
+}
+
+func (h *headerReader) packInfo(s *streamsInfo) {
+	s.packPos = h.number()
+	s.packSizes = make([]uint64, h.count())
+
+	for h.err == nil {
+		switch h.byte() {
+		case idEnd:
+			return
+		case idSize:
+			for i := range s.packSizes {
+				s.packSizes[i] = h.number()
+			}
+		case idCRC:
+			h.digests(len(s.packSizes))
+		default:
+			h.skip()
+		}
+	}
+}
+
+func (h *headerReader) locatePacks(s *streamsInfo) {
+	offset := signatureHeaderSize + s.packPos
+	s.packOffsets = make([]uint64, len(s.packSizes))
+
+	for i, size := range s.packSizes {
+		s.packOffsets[i] = offset
+		offset += size
+	}
+
+	packs := 0
+
+	for _, f := range s.folders {
+		f.packStart = packs
+		packs += len(f.packed)
+	}
+
+	if packs > len(s.packSizes) {
+		h.fail()
+	}
+}
+
+func (h *headerReader) unpackInfo(s *streamsInfo) {
+	if h.byte() != idFolder {
+		h.fail()
+		return
+	}
+
+	s.folders = make([]*folder, h.count())
+
+	if h.byte() != 0 {
+		h.fail() // external folders are never written by 7-Zip
+		return
+	}
+
+	for i := range s.folders {
+		if s.folders[i] = h.folder(); h.err != nil {
+			return
+		}
+	}
+
+	if h.byte() != idCodersUnpackSize {
+		h.fail()
+		return
+	}
+
+	for _, f := range s.folders {
+		for i := range f.unpackSizes {
+			f.unpackSizes[i] = h.number()
+		}
+	}
+
+	for h.err == nil {
+		switch h.byte() {
+		case idEnd:
+			return
+		case idCRC:
+			crcs, defined := h.digests(len(s.folders))
+			for i, f := range s.folders {
+				f.crc, f.hasCRC = crcs[i], defined[i]
+			}
+		default:
+			h.skip()
+		}
+	}
+}
+
+func (h *headerReader) folder() *folder {
+	//nolint:exhaustruct // filled in below
+	f := &folder{}
+	numIn, numOut := 0, 0
+
+	for range h.count() {
+		flags := h.byte()
+		if flags&coderAlternatives != 0 {
+			h.fail()
+			return f
+		}
+
+		c := coder{id: string(h.bytes(uint64(flags & coderIDSizeMask))), numIn: 1, numOut: 1, props: nil}
+
+		if flags&coderComplex != 0 {
+			c.numIn, c.numOut = h.count(), h.count()
+		}
+
+		if flags&coderHasProps != 0 {
+			c.props = h.bytes(h.number())
+		}
+
+		numIn += c.numIn
+		numOut += c.numOut
+		f.coders = append(f.coders, c)
+	}
+
+	if numOut == 0 || numIn < numOut-1 {
+		h.fail()
+		return f
+	}
+
+	f.unpackSizes = make([]uint64, numOut)
+	f.bindPairs = make([]bindPair, numOut-1)
+
+	for i := range f.bindPairs {
+		f.bindPairs[i] = bindPair{in: h.count(), out: h.count()}
+		if f.bindPairs[i].in >= numIn || f.bindPairs[i].out >= numOut {
+			h.fail()
+		}
+	}
+
+	if numPacked := numIn - len(f.bindPairs); numPacked == 1 {
+		for in := range numIn {
+			if f.bindPairForIn(in) < 0 {
+				f.packed = []int{in}
+				break
+			}
+		}
+	} else {
+		for range numPacked {
+			f.packed = append(f.packed, h.count())
+		}
+	}
+
+	if f.mainOut() < 0 {
+		h.fail()
+	}
+
+	return f
+}
+
+func defaultSubStreams(folders []*folder) {
+	for _, f := range folders {
+		f.streams = 1
+		f.sizes = []uint64{f.unpackSize()}
+		f.crcs = []uint32{f.crc}
+		f.hasCRCs = []bool{f.hasCRC}
+	}
+}
+
+// subStreamsInfo splits each folder's output into the files stored in it.
+func (h *headerReader) subStreamsInfo(folders []*folder) {
+	for _, f := range folders {
+		f.streams = 1
+	}
+
+	id := h.byte()
+
+	if id == idNumUnpackStream {
+		for _, f := range folders {
+			f.streams = h.count()
+		}
+
+		id = h.byte()
+	}
+
+	for _, f := range folders {
+		f.sizes = make([]uint64, 0, f.streams)
+		f.crcs = make([]uint32, f.streams)
+		f.hasCRCs = make([]bool, f.streams)
+
+		if f.streams == 0 {
+			continue
+		}
+
+		if id != idSize && f.streams > 1 {
+			h.fail()
+			return
+		}
+
+		var sum uint64
+
+		if id == idSize {
+			for range f.streams - 1 {
+				size := h.number()
+				f.sizes = append(f.sizes, size)
+				sum += size
+			}
+		}
+
+		if sum > f.unpackSize() {
+			h.fail()
+			return
+		}
+
+		f.sizes = append(f.sizes, f.unpackSize()-sum)
+	}
+
+	if id == idSize {
+		id = h.byte()
+	}
+
+	missing := 0
+
+	for _, f := range folders {
+		if f.streams == 1 && f.hasCRC {
+			f.crcs[0], f.hasCRCs[0] = f.crc, true
+		} else {
+			missing += f.streams
+		}
+	}
+
+	for h.err == nil && id != idEnd {
+		if id == idCRC {
+			h.assignDigests(folders, missing)
+		} else {
+			h.skip()
+		}
+
+		id = h.byte()
+	}
+}
+
+func (h *headerReader) assignDigests(folders []*folder, n int) {
+	crcs, defined := h.digests(n)
+	next := 0
+
+	for _, f := range folders {
+		if f.streams == 1 && f.hasCRC {
+			continue
+		}
+
+		for i := range f.streams {
+			f.crcs[i], f.hasCRCs[i] = crcs[next], defined[next]
+			next++
+		}
+	}
+}
+
+func (h *headerReader) filesInfo(r *Reader) {
+	files := make([]*File, h.count())
+	for i := range files {
+		//nolint:exhaustruct // filled in from the properties present
+		files[i] = &File{reader: r}
+	}
+
+	var emptyStream, emptyFile []bool
+
+	for h.err == nil {
+		id := h.byte()
+		if id == idEnd {
+			break
+		}
+
+		prop := &headerReader{buf: h.bytes(h.number()), err: h.err}
+
+		switch id {
+		case idEmptyStream:
+			emptyStream = prop.bits(len(files))
+		case idEmptyFile:
+			emptyFile = prop.bits(countTrue(emptyStream))
+		case idName:
+			prop.names(files)
+		case idWinAttributes:
+			defined := prop.defined(len(files))
+			prop.external()
+
+			for i, file := range files {
+				if defined[i] {
+					file.Attributes = prop.uint32()
+				}
+			}
+		case idMTime:
+			defined := prop.defined(len(files))
+			prop.external()
+
+			for i, file := range files {
+				if defined[i] {
+					file.Modified = filetime(prop.uint64())
+				}
+			}
+		}
+
+		if prop.err != nil {
+			h.err = prop.err
+		}
+	}
+
+	if h.err == nil {
+		h.assignStreams(files, r.streams, emptyStream, emptyFile)
+		r.File = files
+	}
+}
+
+func (h *headerReader) external() {
+	if h.byte() != 0 {
+		h.fail()
+	}
+}
+
+func (h *headerReader) names(files []*File) {
+	h.external()
+
+	for _, file := range files {
+		var name []uint16
+
+		for {
+			b := h.bytes(2) //nolint:mnd // names are UTF-16
+			if b == nil {
+				return
+			}
+
+			c := binary.LittleEndian.Uint16(b)
+			if c == 0 {
+				break
+			}
+
+			name = append(name, c)
+		}
+
+		file.Name = strings.ReplaceAll(string(utf16.Decode(name)), `\`, "/")
+	}
+}
+
+func (h *headerReader) assignStreams(files []*File, streams *streamsInfo, emptyStream, emptyFile []bool) {
+	var folders []*folder
+	if streams != nil {
+		folders = streams.folders
+	}
+
+	empty, index, sub := 0, 0, 0
+
+	var offset uint64
+
+	for i, file := range files {
+		if emptyStream != nil && emptyStream[i] {
+			file.IsDir = empty >= len(emptyFile) || !emptyFile[empty]
+			empty++
+
+			continue
+		}
+
+		for index < len(folders) && sub >= folders[index].streams {
+			index, sub, offset = index+1, 0, 0
+		}
+
+		if index == len(folders) {
+			h.fail()
+			return
+		}
+
+		f := folders[index]
+		file.hasStream, file.folder, file.offset = true, index, offset
+		file.Size, file.CRC32, file.hasCRC = f.sizes[sub], f.crcs[sub], f.hasCRCs[sub]
+		offset += file.Size
+		sub++
+	}
+}
+
+func countTrue(bits []bool) int {
+	n := 0
+
+	for _, bit := range bits {
+		if bit {
+			n++
+		}
+	}
+
+	return n
+}
+
+func filetime(ft uint64) time.Time {
+	//nolint:gosec // FILETIME values fit int64 for any realistic date
+	return time.Unix(int64(ft/filetimeSecond)-filetimeUnixOffset, int64(ft%filetimeSecond)*100)
+}
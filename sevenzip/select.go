@@ -0,0 +1,254 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sevenzip
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ricochhet/minicommon/filesystem"
+)
+
+// Overwrite decides what happens to files that already exist in the
+// destination, matching 7z's -ao switches.
+type Overwrite int
+
+const (
+	OverwriteAll Overwrite = iota
+	OverwriteSkip
+	OverwriteRenameNew
+	OverwriteRenameExisting
+)
+
+func (o Overwrite) arg() string {
+	switch o {
+	case OverwriteSkip:
+		return "-aos"
+	case OverwriteRenameNew:
+		return "-aou"
+	case OverwriteRenameExisting:
+		return "-aot"
+	case OverwriteAll:
+	}
+
+	return "-aoa"
+}
+
+// ExtractOptions selects what SzExtractSelected extracts. Include and Exclude
+// hold paths or wildcards inside the archive; a directory selects everything
+// below it and an empty Include selects every file. With Recursive, patterns
+// without a slash also match file names in subdirectories.
+type ExtractOptions struct {
+	Include   []string
+	Exclude   []string
+	Recursive bool
+	Overwrite Overwrite
+}
+
+func (o ExtractOptions) args() []string {
+	args := []string{o.Overwrite.arg()}
+
+	for _, pattern := range o.Include {
+		args = append(args, "-i!"+pattern)
+	}
+
+	for _, pattern := range o.Exclude {
+		args = append(args, "-x!"+pattern)
+	}
+
+	if o.Recursive {
+		args = append(args, "-r")
+	}
+
+	return args
+}
+
+func (o ExtractOptions) selected(name string) bool {
+	if len(o.Include) > 0 && !o.match(o.Include, name) {
+		return false
+	}
+
+	return !o.match(o.Exclude, name)
+}
+
+func (o ExtractOptions) match(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+
+		if o.Recursive && !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(name)); ok {
+				return true
+			}
+		}
+
+		for dir := name; dir != "."; dir = path.Dir(dir) {
+			if ok, _ := path.Match(pattern, dir); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// target returns where a file is written, or false if it is skipped.
+func (o Overwrite) target(path string) (string, bool, error) {
+	if _, err := os.Lstat(path); err != nil {
+		return path, true, nil //nolint:nilerr // nothing to overwrite
+	}
+
+	switch o {
+	case OverwriteSkip:
+		return "", false, nil
+	case OverwriteRenameNew:
+		return freeName(path), true, nil
+	case OverwriteRenameExisting:
+		return path, true, os.Rename(path, freeName(path))
+	case OverwriteAll:
+	}
+
+	return path, true, nil
+}
+
+// freeName returns the first unused name of the form "name_1.ext", as 7z
+// does when renaming.
+func freeName(path string) string {
+	ext := filepath.Ext(path)
+
+	for i := 1; ; i++ {
+		candidate := strings.TrimSuffix(path, ext) + "_" + strconv.Itoa(i) + ext
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+func SzExtractSelected(src, dest string, silent bool, opt ExtractOptions) (ErrorCode, error) {
+	return SzExtractSelectedWithContext(context.TODO(), messengerFor(silent), src, dest, opt)
+}
+
+// SzExtractSelectedWithContext extracts the files selected by opt into dest
+// itself, unlike SzExtract, falling back to the native reader without 7z.
+//
+//nolint:lll // wontfix
+func SzExtractSelectedWithContext(ctx context.Context, messenger Messenger, src, dest string, opt ExtractOptions) (ErrorCode, error) {
+	outputs := func() []string { return []string{dest} }
+
+	bin, err := ResolveBinary()
+	if err != nil {
+		partial := newPartialOutput(outputs)
+
+		if err := nativeExtract(ctx, messenger, src, dest, opt); err != nil {
+			return partial.failed(ctx, CouldNotExtract, err)
+		}
+
+		return NoError, nil
+	}
+
+	return runWithContext(ctx, bin, messenger, CouldNotExtract, outputs, append([]string{"x", src, "-o" + dest}, opt.args()...)...)
+}
+
+func SzCompressList(base string, files []string, dest string, silent bool, opts ...Options) (ErrorCode, error) {
+	return SzCompressListWithContext(context.TODO(), messengerFor(silent), base, files, dest, opts...)
+}
+
+// SzCompressListWithContext archives files, given relative to base or as
+// paths inside it, under their names relative to base. The names are passed
+// to 7z through a generated list file, so the list is not limited by the
+// command line length.
+//
+//nolint:lll // wontfix
+func SzCompressListWithContext(ctx context.Context, messenger Messenger, base string, files []string, dest string, opts ...Options) (ErrorCode, error) {
+	bin, err := ResolveBinary()
+	if err != nil {
+		return ProcessNotFound, err
+	}
+
+	opt := assureOptions(opts...)
+	if !bin.Supports(opt.format()) {
+		return CouldNotCompress, fmt.Errorf("%w: %s cannot write %s archives", ErrUnsupportedFormat, bin.Path, opt.format())
+	}
+
+	switches, err := opt.Args()
+	if err != nil {
+		return CouldNotCompress, err
+	}
+
+	// 7z runs in base, so the archive and the list file need absolute paths.
+	if dest, err = filepath.Abs(dest); err != nil {
+		return CouldNotCompress, err
+	}
+
+	list, err := writeListFile(base, files)
+	if err != nil {
+		return CouldNotCompress, err
+	}
+	defer os.Remove(list)
+
+	args := append([]string{"a", dest, "-scsUTF-8", "@" + list}, switches...)
+	partial := newPartialOutput(compressOutputs(dest))
+
+	if err := runInDir(ctx, bin, base, messenger, args...); err != nil {
+		return partial.failed(ctx, CouldNotCompress, err)
+	}
+
+	return NoError, nil
+}
+
+// writeListFile writes the names of files relative to base, one per line, to
+// a temporary file and returns its path.
+func writeListFile(base string, files []string) (string, error) {
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+
+	for _, file := range files {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(absBase, file)
+		}
+
+		name, err := filepath.Rel(absBase, file)
+		if err != nil || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("%w: %s", filesystem.ErrUnsafePath, file)
+		}
+
+		b.WriteString(name + "\n")
+	}
+
+	list, err := os.CreateTemp("", "7z-list-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer list.Close()
+
+	if _, err := list.WriteString(b.String()); err != nil {
+		os.Remove(list.Name())
+		return "", err
+	}
+
+	return list.Name(), list.Close()
+}
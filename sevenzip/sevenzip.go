@@ -19,68 +19,233 @@
 package sevenzip
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
 	"github.com/ricochhet/minicommon/filesystem"
 	"github.com/ricochhet/minicommon/process"
 )
 
+const errorCaptureLimit = 4 << 10
+
+//nolint:gochecknoglobals // wontfix
+var volumePattern = regexp.MustCompile(`^\d{3,}$`)
+
+// SzExtract extracts everything in src into a directory of dest named after
+// the archive. SzExtractSelected extracts into dest itself.
 func SzExtract(src, dest string, silent bool) (ErrorCode, error) {
-	if filesystem.Exists("redist/win64/7z.exe") {
-		if err := process.RunFile("redist/win64/7z.exe", true, true, false, "x", src, "-o"+dest+"/*"); err != nil {
-			return CouldNotCompress, err
+	return SzExtractWithContext(context.TODO(), messengerFor(silent), src, dest)
+}
+
+func SzExtractWithMessenger(src, dest string, messenger Messenger) (ErrorCode, error) {
+	return SzExtractWithContext(context.TODO(), messenger, src, dest)
+}
+
+// SzExtractWithContext kills 7z once ctx is done and removes the output
+// directory if this call created it.
+func SzExtractWithContext(ctx context.Context, messenger Messenger, src, dest string) (ErrorCode, error) {
+	bin, err := ResolveBinary()
+	if err != nil {
+		partial := newPartialOutput(extractOutputs(src, dest))
+
+		if err := NativeExtractWithContext(ctx, messenger, src, archiveDir(src, dest)); err != nil {
+			return partial.failed(ctx, CouldNotExtract, err)
 		}
 
 		return NoError, nil
 	}
 
-	if !process.DoesFileExist("7z") {
+	return runWithContext(ctx, bin, messenger, CouldNotExtract, extractOutputs(src, dest), "x", src, "-o"+dest+"/*")
+}
+
+func SzBinExtract(src, dest, bin string, silent bool) (ErrorCode, error) {
+	return SzBinExtractWithContext(context.TODO(), messengerFor(silent), src, dest, bin)
+}
+
+func SzBinExtractWithContext(ctx context.Context, messenger Messenger, src, dest, bin string) (ErrorCode, error) {
+	if !filesystem.Exists(bin) {
 		return ProcessNotFound, ErrSevenZipNotFound
 	}
 
-	if err := process.RunFile("7z", true, false, silent, "x", src, "-o"+dest+"/*"); err != nil {
-		return CouldNotExtract, err
+	return runWithContext(ctx, relativeBinary(bin), messenger, CouldNotExtract, extractOutputs(src, dest), "x", src, "-o"+dest+"/*")
+}
+
+func SzCompress(src, dest string, silent bool, opts ...Options) (ErrorCode, error) {
+	return SzCompressWithContext(context.TODO(), messengerFor(silent), src, dest, opts...)
+}
+
+func SzCompressWithMessenger(src, dest string, messenger Messenger, opts ...Options) (ErrorCode, error) {
+	return SzCompressWithContext(context.TODO(), messenger, src, dest, opts...)
+}
+
+// SzCompressWithContext kills 7z once ctx is done and removes the archive if
+// this call created it.
+func SzCompressWithContext(ctx context.Context, messenger Messenger, src, dest string, opts ...Options) (ErrorCode, error) {
+	bin, err := ResolveBinary()
+	if err != nil {
+		return ProcessNotFound, err
+	}
+
+	opt := assureOptions(opts...)
+	if !bin.Supports(opt.format()) {
+		return CouldNotCompress, fmt.Errorf("%w: %s cannot write %s archives", ErrUnsupportedFormat, bin.Path, opt.format())
 	}
 
-	return NoError, nil
+	args, err := compressArgs(src, dest, opt)
+	if err != nil {
+		return CouldNotCompress, err
+	}
+
+	return runWithContext(ctx, bin, messenger, CouldNotCompress, compressOutputs(dest), args...)
 }
 
-func SzBinExtract(src, dest, bin string, silent bool) (ErrorCode, error) {
+func SzBinCompress(src, dest, bin string, silent bool, opts ...Options) (ErrorCode, error) {
+	return SzBinCompressWithContext(context.TODO(), messengerFor(silent), src, dest, bin, opts...)
+}
+
+//nolint:lll // wontfix
+func SzBinCompressWithContext(ctx context.Context, messenger Messenger, src, dest, bin string, opts ...Options) (ErrorCode, error) {
 	if !filesystem.Exists(bin) {
 		return ProcessNotFound, ErrSevenZipNotFound
 	}
 
-	if err := process.RunFile(bin, true, true, silent, "x", src, "-o"+dest+"/*"); err != nil {
+	args, err := compressArgs(src, dest, assureOptions(opts...))
+	if err != nil {
 		return CouldNotCompress, err
 	}
 
-	return NoError, nil
+	return runWithContext(ctx, relativeBinary(bin), messenger, CouldNotCompress, compressOutputs(dest), args...)
 }
 
-func SzCompress(src, dest string, silent bool, opts ...Options) (ErrorCode, error) {
-	opt := assureOptions(opts...)
+func compressArgs(src, dest string, opt Options) ([]string, error) {
+	switches, err := opt.Args()
+	if err != nil {
+		return nil, err
+	}
 
-	if !process.DoesFileExist("7z") {
-		return ProcessNotFound, ErrSevenZipNotFound
+	return append([]string{"a", dest, src + "/*"}, switches...), nil
+}
+
+// compressOutputs lists what 7z may leave behind when it is killed: the
+// archive or its volumes, and the temporary archive it writes while updating
+// an existing one.
+func compressOutputs(dest string) func() []string {
+	return func() []string {
+		return append([]string{dest, dest + ".tmp"}, volumes(dest)...)
 	}
+}
 
-	//nolint:lll // wontfix
-	if err := process.RunFile("7z", true, false, silent, "a", "-t"+opt.SzCompressionFormat, dest, src+"/*", opt.SzCompressionLevel, opt.SzCompressionMethod, opt.SzCompressionDictionarySize, opt.SzCompressionFastBytes, opt.SzCompressionSolidBlockSize, opt.SzCompressionMultithreading, opt.SzCompressionMemory); err != nil {
-		return CouldNotCompress, err
+// volumes returns the existing volumes of dest, named dest.001, dest.002...
+func volumes(dest string) []string {
+	entries, err := os.ReadDir(filepath.Dir(dest))
+	if err != nil {
+		return nil
+	}
+
+	var paths []string
+
+	for _, entry := range entries {
+		if suffix, ok := strings.CutPrefix(entry.Name(), filepath.Base(dest)+"."); ok && volumePattern.MatchString(suffix) {
+			paths = append(paths, filepath.Join(filepath.Dir(dest), entry.Name()))
+		}
+	}
+
+	return paths
+}
+
+func extractOutputs(src, dest string) func() []string {
+	return func() []string {
+		return []string{archiveDir(src, dest)}
+	}
+}
+
+// relativeBinary is a binary given by the caller, relative to the executable.
+func relativeBinary(path string) *Binary {
+	return &Binary{Path: path, Version: "", Major: 0, Minor: 0, P7zip: false, Formats: nil, relative: true}
+}
+
+// runWithContext runs 7z and reports failure, or Cancelled when ctx ended
+// the run, after removing the outputs that did not exist beforehand.
+//
+//nolint:lll // wontfix
+func runWithContext(ctx context.Context, bin *Binary, messenger Messenger, failure ErrorCode, outputs func() []string, args ...string) (ErrorCode, error) {
+	partial := newPartialOutput(outputs)
+
+	if err := run(ctx, bin, messenger, args...); err != nil {
+		return partial.failed(ctx, failure, err)
 	}
 
 	return NoError, nil
 }
 
-func SzBinCompress(src, dest, bin string, silent bool, opts ...Options) (ErrorCode, error) {
-	opt := assureOptions(opts...)
+// run executes 7z with progress (-bsp1) and processed file names (-bb1) on
+// stdout and reports both through the messenger. Older versions without
+// these switches only report completion.
+func run(ctx context.Context, bin *Binary, messenger Messenger, args ...string) error {
+	return runInDir(ctx, bin, "", messenger, args...)
+}
 
-	if !filesystem.Exists(bin) {
-		return ProcessNotFound, ErrSevenZipNotFound
+func runInDir(ctx context.Context, bin *Binary, dir string, messenger Messenger, args ...string) error {
+	output := newOutputParser(messenger)
+
+	if bin.progress() {
+		args = append(args, "-bsp1", "-bb1")
 	}
 
-	//nolint:lll // wontfix
-	if err := process.RunFile(bin, true, true, silent, "a", "-t"+opt.SzCompressionFormat, dest, src+"/*", opt.SzCompressionLevel, opt.SzCompressionMethod, opt.SzCompressionDictionarySize, opt.SzCompressionFastBytes, opt.SzCompressionSolidBlockSize, opt.SzCompressionMultithreading, opt.SzCompressionMemory); err != nil {
-		return CouldNotCompress, err
+	cmd := bin.command(dir, output, args...)
+	cmd.CaptureLimit = errorCaptureLimit
+
+	if result, err := process.Run(ctx, cmd); err != nil {
+		if message := strings.TrimSpace(string(result.Stderr)); message != "" && ctx.Err() == nil {
+			return fmt.Errorf("%w: %s", err, message)
+		}
+
+		return err
 	}
 
-	return NoError, nil
+	output.finish()
+
+	return nil
+}
+
+// partialOutput remembers which outputs existed before a run, so that a
+// cancelled run only removes what it created itself.
+type partialOutput struct {
+	outputs func() []string
+	existed map[string]bool
+}
+
+func newPartialOutput(outputs func() []string) partialOutput {
+	existed := make(map[string]bool)
+
+	for _, path := range outputs() {
+		existed[path] = filesystem.Exists(path)
+	}
+
+	return partialOutput{outputs: outputs, existed: existed}
+}
+
+// failed removes the partial output of a cancelled run and returns Cancelled
+// with the context's error; other failures are returned as failure.
+func (p partialOutput) failed(ctx context.Context, failure ErrorCode, err error) (ErrorCode, error) {
+	if ctx.Err() == nil {
+		return failure, err
+	}
+
+	for _, path := range p.outputs() {
+		if p.existed[path] {
+			continue
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			return Cancelled, errors.Join(ctx.Err(), err)
+		}
+	}
+
+	return Cancelled, ctx.Err()
 }
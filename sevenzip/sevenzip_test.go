@@ -0,0 +1,560 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sevenzip_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ricochhet/minicommon/sevenzip"
+	"github.com/ricochhet/minicommon/zip"
+)
+
+// The lzma, lzma2 and copy archives hold the same solid set of files. bcj.7z
+// stores one LZMA2+BCJ folder per file and bcj2.7z a single BCJ2 folder
+// behind an LZMA compressed header.
+//
+//nolint:gochecknoglobals // test only
+var (
+	textFiles = map[string]string{
+		"a.txt":     "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447",
+		"sub/b.txt": "a8dc9b5f56ef95a3eb8d820b10e3c383d95db9d73edab9f036c5d58737243429",
+		"empty":     "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	}
+	binaryFiles = map[string]string{
+		"one.exe": "3ab528f1b794fb7a960a14a5e50e03d5e295513ed05060f0f8aaed6c5ee00956",
+		"two.exe": "af0ada5e674d422a3aee0498360a4504f03b59185c9f68059b8c81c903e524cd",
+	}
+)
+
+func checkFiles(t *testing.T, dir string, want map[string]string) {
+	t.Helper()
+
+	for name, sum := range want {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := sha256.Sum256(data); hex.EncodeToString(got[:]) != sum {
+			t.Fatalf("unexpected content for %s", name)
+		}
+	}
+}
+
+func TestNativeExtract(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"lzma", "lzma2", "copy"} {
+		dir := t.TempDir()
+
+		if err := sevenzip.NativeExtract(filepath.Join("testdata", name+".7z"), dir); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		checkFiles(t, dir, textFiles)
+	}
+
+	dir := t.TempDir()
+
+	if err := sevenzip.NativeExtract(filepath.Join("testdata", "bcj.7z"), dir); err != nil {
+		t.Fatal(err)
+	}
+
+	checkFiles(t, filepath.Join(dir, "bin"), binaryFiles)
+
+	dir = t.TempDir()
+
+	if err := sevenzip.NativeExtract(filepath.Join("testdata", "bcj2.7z"), dir); err != nil {
+		t.Fatal(err)
+	}
+
+	checkFiles(t, dir, binaryFiles)
+}
+
+func TestOpenReader(t *testing.T) {
+	t.Parallel()
+
+	archive, err := sevenzip.OpenReader(filepath.Join("testdata", "bcj2.7z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	if len(archive.File) != 2 || archive.File[1].Name != "two.exe" {
+		t.Fatalf("unexpected entries: %v", archive.File)
+	}
+
+	// two.exe is stored after one.exe in the same solid block.
+	file, err := archive.File[1].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sha256.Sum256(data); hex.EncodeToString(got[:]) != binaryFiles["two.exe"] {
+		t.Fatal("unexpected content for two.exe")
+	}
+}
+
+// corruptCopy returns copy.7z with a byte of a.txt flipped. The first packed
+// bytes are the start of a.txt in the stored archive.
+func corruptCopy(t *testing.T) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "copy.7z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[32] ^= 0xFF
+
+	return data
+}
+
+func TestNativeExtractCorrupt(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "corrupt.7z")
+	if err := os.WriteFile(path, corruptCopy(t), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sevenzip.NativeExtract(path, t.TempDir()); !errors.Is(err, sevenzip.ErrChecksum) {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+}
+
+// fakeSevenZip is the output of "7z x -bsp1 -bb1", with the progress line
+// redrawn in place as 7z does on a terminal.
+const fakeSevenZip = `#!/bin/sh
+case "$*" in *"-bsp1 -bb1"*) ;; *) exit 2 ;; esac
+printf '\n7-Zip [64] 16.02\n\nExtracting archive: x.7z\n--\nPath = x.7z\nType = 7z\n\n'
+printf '  0%%\b\b\b\b    \b\b\b\b 40%% 1 - a.txt\b\b\b\b\b\b\b\b\b\b\b\b\b\b              '
+printf '\b\b\b\b\b\b\b\b\b\b\b\b\b\b- a.txt\n- sub/b.txt\n\nEverything is Ok\n'
+`
+
+func setFakeSevenZip(t *testing.T, script string) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script in place of 7z")
+	}
+
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "7z"), []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", bin)
+}
+
+func TestSzExtractMessenger(t *testing.T) {
+	setFakeSevenZip(t, fakeSevenZip)
+
+	var progress []int
+
+	var files []string
+
+	messenger := sevenzip.Messenger{
+		Progress: func(percent int) { progress = append(progress, percent) },
+		File:     func(name string) { files = append(files, name) },
+	}
+
+	if _, err := sevenzip.SzExtractWithMessenger("x.7z", t.TempDir(), messenger); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(progress, []int{0, 40, 100}) || !slices.Equal(files, []string{"a.txt", "sub/b.txt"}) {
+		t.Fatalf("unexpected events: %v %v", progress, files)
+	}
+}
+
+func TestSzExtractFallback(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	var files []string
+
+	dir := t.TempDir()
+	messenger := sevenzip.Messenger{Progress: nil, File: func(name string) { files = append(files, name) }}
+
+	if _, err := sevenzip.SzExtractWithMessenger(filepath.Join("testdata", "lzma2.7z"), dir, messenger); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(files, []string{"a.txt", "sub/b.txt", "empty"}) {
+		t.Fatalf("unexpected files: %v", files)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "lzma2", "a.txt"))
+	if err != nil || !strings.HasPrefix(string(data), "hello") {
+		t.Fatalf("unexpected fallback extraction: %v", err)
+	}
+}
+
+// fakeSevenZipList answers "7z l -slt" and "7z t" with the output of 7-Zip 16.
+const fakeSevenZipList = `#!/bin/sh
+case "$1" in
+l) printf '7-Zip [64] 16.02\n\nListing archive: x.7z\n\n--\nPath = x.7z\nType = 7z\n\n----------\n'
+   printf 'Path = sub\nSize = 0\nPacked Size = 0\nModified = 2024-01-02 03:04:05\n'
+   printf 'Attributes = D drwxr-xr-x\nCRC = \nEncrypted = -\nMethod = \n\n'
+   printf 'Path = sub/b.txt\nSize = 300\nPacked Size = 120\nModified = 2024-01-02 03:04:05.5\nAttributes = A -rw-r--r--\n'
+   printf 'CRC = AF083B2D\nEncrypted = +\nMethod = LZMA2:12 7zAES\nBlock = 0\n\n' ;;
+t) printf 'Testing archive: x.7z\n' ; printf 'ERROR: CRC Failed : sub/b.txt\n' >&2 ; exit 2 ;;
+esac
+`
+
+func TestSzList(t *testing.T) {
+	setFakeSevenZip(t, fakeSevenZipList)
+
+	entries, err := sevenzip.SzList("x.7z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || !entries[0].IsDir || entries[0].Path != "sub" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	file := entries[1]
+	if file.Size != 300 || file.PackedSize != 120 || file.CRC32 != 0xAF083B2D || !file.Encrypted ||
+		file.Method != "LZMA2:12 7zAES" || file.Modified.Nanosecond() != 500000000 {
+		t.Fatalf("unexpected entry: %+v", file)
+	}
+
+	failed, err := sevenzip.SzTest("x.7z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(failed) != 1 || failed[0].Name != "sub/b.txt" || !errors.Is(failed[0], sevenzip.ErrChecksum) {
+		t.Fatalf("unexpected failures: %v", failed)
+	}
+}
+
+func TestSzListFallback(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	entries, err := sevenzip.SzList(filepath.Join("testdata", "bcj2.7z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[0].Method != "LZMA LZMA LZMA BCJ2" || entries[0].PackedSize == 0 || entries[1].PackedSize != 0 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	path := filepath.Join(t.TempDir(), "corrupt.7z")
+	if err := os.WriteFile(path, corruptCopy(t), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	failed, err := sevenzip.SzTest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(failed) != 1 || failed[0].Name != "a.txt" || !errors.Is(failed[0], sevenzip.ErrChecksum) {
+		t.Fatalf("unexpected failures: %v", failed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := sevenzip.SzTestWithContext(ctx, path); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the test to be cancelled, got %v", err)
+	}
+}
+
+// fakeSevenZipSlow starts writing the archive and leaves a child holding its
+// output open, so the run only ends early if the whole tree is killed.
+const fakeSevenZipSlow = `#!/bin/sh
+[ $# -eq 0 ] && exit 0
+PATH=/usr/bin:/bin
+printf 'partial' > "$2"
+printf 'partial' > "$2.001"
+sleep 30 &
+sleep 30
+`
+
+func TestSzCompressCancel(t *testing.T) {
+	setFakeSevenZip(t, fakeSevenZipSlow)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	dest := filepath.Join(t.TempDir(), "out.7z")
+	opt := sevenzip.DefaultOptions()
+	opt.VolumeSize = 1 << 20
+	start := time.Now()
+
+	code, err := sevenzip.SzCompressWithContext(ctx, sevenzip.Messenger{Progress: nil, File: nil}, t.TempDir(), dest, opt)
+	if code != sevenzip.Cancelled || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected cancellation, got %v %v", code, err)
+	}
+
+	if time.Since(start) > 10*time.Second {
+		t.Fatal("7z was not killed")
+	}
+
+	for _, path := range []string{dest, dest + ".001"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("partial archive was left behind: %v", err)
+		}
+	}
+}
+
+//nolint:exhaustruct // test only
+func TestOptionsArgs(t *testing.T) {
+	t.Parallel()
+
+	opt := sevenzip.Options{
+		Format:         "7z",
+		Level:          5,
+		Method:         sevenzip.LZMA2,
+		DictionarySize: 16 << 20,
+		FastBytes:      32,
+		SolidBlockSize: 0,
+		Threads:        4,
+		MemoryFraction: 0,
+		VolumeSize:     100 << 20,
+		Password:       "secret",
+		EncryptHeaders: true,
+	}
+
+	args, err := opt.Args()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"-t7z", "-mx=5", "-m0=LZMA2", "-md=16777216b", "-mfb=32", "-ms=off", "-mmt=4", "-v104857600b", "-psecret", "-mhe=on"}
+	if !slices.Equal(args, want) {
+		t.Fatalf("unexpected args: %v", args)
+	}
+
+	if args, err := (sevenzip.Options{Method: sevenzip.Copy}).Args(); err != nil || slices.ContainsFunc(args, func(arg string) bool {
+		return strings.HasPrefix(arg, "-mx")
+	}) {
+		t.Fatalf("a zero level was passed to 7z: %v %v", args, err)
+	}
+
+	for _, invalid := range []sevenzip.Options{
+		{Format: "rar"},
+		{Level: 10},
+		{Format: "zip", Method: sevenzip.LZMA2},
+		{FastBytes: 300},
+		{MemoryFraction: 1.5},
+		{EncryptHeaders: true},
+	} {
+		if err := invalid.Validate(); !errors.Is(err, sevenzip.ErrInvalidOptions) {
+			t.Fatalf("expected %+v to be invalid, got %v", invalid, err)
+		}
+	}
+
+	if err := sevenzip.DefaultOptions().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeSevenZipReduced prints the banner of the reduced 7zr build.
+const fakeSevenZipReduced = `#!/bin/sh
+printf '\n7-Zip (r) 23.01 (x64) : Copyright (c) 1999-2023 Igor Pavlov : 2023-06-20\n'
+`
+
+func TestResolveBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script in place of 7z")
+	}
+
+	t.Setenv("PATH", t.TempDir())
+
+	path := filepath.Join(t.TempDir(), "7zr")
+	if err := os.WriteFile(path, []byte(fakeSevenZipReduced), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(sevenzip.EnvBinary, path)
+
+	bin, err := sevenzip.ResolveBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bin.Path != path || bin.Version != "23.01" || bin.P7zip || !bin.Supports("7z") || bin.Supports("zip") {
+		t.Fatalf("unexpected binary: %+v", bin)
+	}
+
+	opt := sevenzip.DefaultOptions()
+	opt.Format = "zip"
+	opt.Method = sevenzip.Deflate
+
+	if _, err := sevenzip.SzCompress(t.TempDir(), "out.zip", true, opt); !errors.Is(err, sevenzip.ErrUnsupportedFormat) {
+		t.Fatalf("expected unsupported format, got %v", err)
+	}
+	// The cached binary is not used once the environment names another.
+	other := filepath.Join(t.TempDir(), "7zr")
+	if err := os.WriteFile(other, []byte(fakeSevenZipReduced), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(sevenzip.EnvBinary, other)
+
+	if bin, err := sevenzip.ResolveBinary(); err != nil || bin.Path != other {
+		t.Fatalf("the cached binary was used: %+v %v", bin, err)
+	}
+}
+
+func TestSzExtractSelectedFallback(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("existing"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := sevenzip.ExtractOptions{
+		Include:   []string{"*.txt"},
+		Exclude:   []string{"sub"},
+		Recursive: true,
+		Overwrite: sevenzip.OverwriteRenameNew,
+	}
+
+	if _, err := sevenzip.SzExtractSelected(filepath.Join("testdata", "lzma2.7z"), dir, true, opt); err != nil {
+		t.Fatal(err)
+	}
+
+	checkFiles(t, dir, map[string]string{"a_1.txt": textFiles["a.txt"]})
+
+	for _, name := range []string{"sub/b.txt", "empty"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Fatalf("%s should not have been extracted", name)
+		}
+	}
+
+	// sub/b.txt is stored after a.txt in the same solid block.
+	opt = sevenzip.ExtractOptions{Include: []string{"sub/b.txt"}, Exclude: nil, Recursive: false, Overwrite: sevenzip.OverwriteSkip}
+
+	if _, err := sevenzip.SzExtractSelected(filepath.Join("testdata", "lzma2.7z"), dir, true, opt); err != nil {
+		t.Fatal(err)
+	}
+
+	checkFiles(t, dir, map[string]string{"a.txt": sha256Hex("existing"), "sub/b.txt": textFiles["sub/b.txt"]})
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// fakeSevenZipRecord records its working directory and list file.
+const fakeSevenZipRecord = `#!/bin/sh
+[ $# -eq 0 ] && exit 0
+PATH=/usr/bin:/bin
+for arg; do case "$arg" in @*) list="${arg#@}" ;; esac; done
+{ pwd; cat "$list"; } > "$2"
+`
+
+func TestSzCompressList(t *testing.T) {
+	setFakeSevenZip(t, fakeSevenZipRecord)
+
+	base := t.TempDir()
+	dest := filepath.Join(t.TempDir(), "out.7z")
+
+	files := []string{"a.txt", filepath.Join(base, "sub", "b.txt")}
+	if _, err := sevenzip.SzCompressList(base, files, dest, true); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := base + "\na.txt\nsub/b.txt\n"; string(data) != want {
+		t.Fatalf("unexpected list: %q", data)
+	}
+
+	if _, err := sevenzip.SzCompressList(base, []string{"../x"}, dest, true); err == nil {
+		t.Fatal("expected paths outside base to be rejected")
+	}
+}
+
+//nolint:exhaustruct // test only
+func TestNestedFormat(t *testing.T) {
+	t.Parallel()
+
+	payload, err := os.ReadFile(filepath.Join("testdata", "lzma2.7z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var document bytes.Buffer
+	if err := zip.ZipFS(fstest.MapFS{"word/document.xml": {Data: []byte("<w/>")}}, &document); err != nil {
+		t.Fatal(err)
+	}
+
+	var outer bytes.Buffer
+	if err := zip.ZipFS(fstest.MapFS{"payload": {Data: payload}, "report.docx": {Data: document.Bytes()}}, &outer); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "outer.zip")
+
+	if err := os.WriteFile(path, outer.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	messenger := zip.Messenger{AddedFile: func(string) {}}
+	opt := zip.Options{NestedDepth: 2, NestedFormats: []zip.NestedFormat{sevenzip.NestedFormat{}}, NestedExtensions: []string{"", ".zip"}}
+	out := filepath.Join(dir, "out")
+
+	if err := zip.UnzipWithFilter(path, out, zip.Filter{}, messenger, opt); err != nil {
+		t.Fatal(err)
+	}
+
+	checkFiles(t, filepath.Join(out, "payload.d"), textFiles)
+
+	if _, err := os.Stat(filepath.Join(out, "report.docx")); err != nil {
+		t.Fatal("the document was treated as an archive")
+	}
+
+	// Enough for the outer archive but not for what is nested in it.
+	opt.MaxSize = uint64(len(payload) + document.Len() + 1)
+	if err := zip.UnzipWithFilter(path, filepath.Join(dir, "limited"), zip.Filter{}, messenger, opt); !errors.Is(err, zip.ErrLimitExceeded) {
+		t.Fatalf("expected limit error, got %v", err)
+	}
+}
@@ -0,0 +1,450 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // mandated by the WinZip AES specification
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/ricochhet/minicommon/crypto"
+)
+
+// WinZip AES encryption, see https://www.winzip.com/en/support/aes-encryption/.
+const (
+	methodWinZipAES     = 99
+	extraWinZipAES      = 0x9901
+	extraWinZipAESSize  = 7
+	aesVendorID         = "AE"
+	aesVersion1         = 1
+	aesVersion2         = 2
+	aesIterations       = 1000
+	aesVerifierSize     = 2
+	aesAuthCodeSize     = 10
+	flagEncrypted       = 0x1
+	flagDataDescriptor  = 0x8
+	zipVersion45        = 45
+	uint32max           = math.MaxUint32
+	extraHeaderSize     = 4
+	aesStrengthAES128   = 1
+	aesStrengthAES192   = 2
+	aesStrengthAES256   = 3
+	aesCounterBlockSize = aes.BlockSize
+)
+
+type Encryption int
+
+const (
+	AES256 Encryption = iota
+	AES192
+	AES128
+)
+
+var (
+	ErrPasswordRequired = errors.New("entry is encrypted but no password was given")
+	ErrWrongPassword    = errors.New("wrong password for encrypted entry")
+	ErrAuthentication   = errors.New("encrypted entry failed authentication")
+	errInvalidAESExtra  = errors.New("invalid WinZip AES extra field")
+)
+
+type aesExtra struct {
+	version  uint16
+	strength byte
+	method   uint16
+}
+
+func (e Encryption) strength() byte {
+	switch e {
+	case AES128:
+		return aesStrengthAES128
+	case AES192:
+		return aesStrengthAES192
+	case AES256:
+		return aesStrengthAES256
+	}
+
+	return aesStrengthAES256
+}
+
+func aesKeySize(strength byte) (int, error) {
+	switch strength {
+	case aesStrengthAES128:
+		return 16, nil //nolint:mnd // AES-128
+	case aesStrengthAES192:
+		return 24, nil //nolint:mnd // AES-192
+	case aesStrengthAES256:
+		return 32, nil //nolint:mnd // AES-256
+	}
+
+	return 0, errInvalidAESExtra
+}
+
+func isEncrypted(file *zip.File) bool {
+	return file.Method == methodWinZipAES && file.Flags&flagEncrypted != 0
+}
+
+func parseAESExtra(extra []byte) (aesExtra, error) {
+	for len(extra) >= extraHeaderSize {
+		tag := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		extra = extra[extraHeaderSize:]
+
+		if size > len(extra) {
+			break
+		}
+
+		if tag == extraWinZipAES && size == extraWinZipAESSize && string(extra[2:4]) == aesVendorID {
+			return aesExtra{
+				version:  binary.LittleEndian.Uint16(extra[0:2]),
+				strength: extra[4],
+				method:   binary.LittleEndian.Uint16(extra[5:7]),
+			}, nil
+		}
+
+		extra = extra[size:]
+	}
+
+	return aesExtra{}, errInvalidAESExtra //nolint:exhaustruct // wontfix
+}
+
+func appendAESExtra(extra []byte, strength byte, method uint16) []byte {
+	field := make([]byte, extraHeaderSize+extraWinZipAESSize)
+	binary.LittleEndian.PutUint16(field[0:2], extraWinZipAES)
+	binary.LittleEndian.PutUint16(field[2:4], extraWinZipAESSize)
+	binary.LittleEndian.PutUint16(field[4:6], aesVersion2)
+	copy(field[6:8], aesVendorID)
+	field[8] = strength
+	binary.LittleEndian.PutUint16(field[9:11], method)
+
+	return append(extra, field...)
+}
+
+func deriveAESKeys(password string, salt []byte, keySize int) (cipher.Block, hash.Hash, []byte, error) {
+	keys := crypto.PBKDF2([]byte(password), salt, aesIterations, 2*keySize+aesVerifierSize, sha1.New)
+
+	block, err := aes.NewCipher(keys[:keySize])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return block, hmac.New(sha1.New, keys[keySize:2*keySize]), keys[2*keySize:], nil
+}
+
+// winzipCTR is AES in counter mode with the little-endian counter, starting at
+// one, that WinZip uses instead of the big-endian counter of cipher.NewCTR.
+type winzipCTR struct {
+	block   cipher.Block
+	counter [aesCounterBlockSize]byte
+	stream  [aesCounterBlockSize]byte
+	pos     int
+}
+
+func newWinzipCTR(block cipher.Block) *winzipCTR {
+	return &winzipCTR{block: block, counter: [aesCounterBlockSize]byte{}, stream: [aesCounterBlockSize]byte{}, pos: aesCounterBlockSize}
+}
+
+func (c *winzipCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.pos == aesCounterBlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.pos = 0
+		}
+
+		dst[i] = src[i] ^ c.stream[c.pos]
+		c.pos++
+	}
+}
+
+func openEntry(file *zip.File, password string) (io.ReadCloser, error) {
+	if !isEncrypted(file) {
+		return file.Open()
+	}
+
+	if password == "" {
+		return nil, ErrPasswordRequired
+	}
+
+	extra, err := parseAESExtra(file.Extra)
+	if err != nil {
+		return nil, err
+	}
+
+	keySize, err := aesKeySize(extra.strength)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := file.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, keySize/2+aesVerifierSize)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, err
+	}
+
+	block, mac, verifier, err := deriveAESKeys(password, header[:keySize/2], keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare(verifier, header[keySize/2:]) != 1 {
+		return nil, ErrWrongPassword
+	}
+
+	overhead := uint64(len(header) + aesAuthCodeSize)
+	if file.CompressedSize64 < overhead {
+		return nil, zip.ErrFormat
+	}
+
+	decrypt := &aesReader{
+		src:    io.LimitReader(raw, int64(file.CompressedSize64-overhead)), //nolint:gosec // bounded by the archive size
+		raw:    raw,
+		ctr:    newWinzipCTR(block),
+		mac:    mac,
+		verify: true,
+	}
+
+	return decompressAES(decrypt, file, extra)
+}
+
+func decompressAES(decrypt io.Reader, file *zip.File, extra aesExtra) (io.ReadCloser, error) {
+	var readclose io.ReadCloser
+
+	switch extra.method {
+	case zip.Store:
+		readclose = io.NopCloser(decrypt)
+	case zip.Deflate:
+		readclose = &drainReader{ReadCloser: flate.NewReader(decrypt), src: decrypt}
+	default:
+		return nil, zip.ErrAlgorithm
+	}
+
+	if extra.version == aesVersion1 {
+		return &crcReader{ReadCloser: readclose, hash: crc32.NewIEEE(), want: file.CRC32}, nil
+	}
+
+	return readclose, nil
+}
+
+type aesReader struct {
+	src    io.Reader
+	raw    io.Reader
+	ctr    *winzipCTR
+	mac    hash.Hash
+	verify bool
+}
+
+func (r *aesReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if n > 0 {
+		r.mac.Write(p[:n])
+		r.ctr.XORKeyStream(p[:n], p[:n])
+	}
+
+	if errors.Is(err, io.EOF) && r.verify {
+		r.verify = false
+
+		code := make([]byte, aesAuthCodeSize)
+		if _, err := io.ReadFull(r.raw, code); err != nil {
+			return n, err
+		}
+
+		if !hmac.Equal(code, r.mac.Sum(nil)[:aesAuthCodeSize]) {
+			return n, ErrAuthentication
+		}
+	}
+
+	return n, err
+}
+
+// drainReader reads src to the end once the decompressor stops, so that the
+// authentication code is always checked. A failed check takes precedence over
+// the decompressor's own error, since tampered data is rarely valid deflate.
+type drainReader struct {
+	io.ReadCloser
+	src io.Reader
+}
+
+func (r *drainReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == nil {
+		return n, nil
+	}
+
+	if _, drainErr := io.Copy(io.Discard, r.src); drainErr != nil {
+		return n, drainErr
+	}
+
+	return n, err
+}
+
+type crcReader struct {
+	io.ReadCloser
+	hash hash.Hash32
+	want uint32
+}
+
+func (r *crcReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+
+	if errors.Is(err, io.EOF) && r.hash.Sum32() != r.want {
+		return n, zip.ErrChecksum
+	}
+
+	return n, err
+}
+
+// createEncrypted adds an AE-2 entry for header to zipWrite. The returned
+// writer must be closed before the next entry is created, which is when the
+// sizes in header are filled in.
+func createEncrypted(zipWrite *zip.Writer, header *zip.FileHeader, opt Options) (io.WriteCloser, error) {
+	strength := opt.Encryption.strength()
+
+	keySize, err := aesKeySize(strength)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, keySize/2)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	block, mac, verifier, err := deriveAESKeys(opt.Password, salt, keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	method := header.Method
+	if method != zip.Store && method != zip.Deflate {
+		return nil, zip.ErrAlgorithm
+	}
+
+	header.Extra = appendAESExtra(header.Extra, strength, method)
+	header.Method = methodWinZipAES
+	header.Flags |= flagEncrypted | flagDataDescriptor
+	header.CRC32 = 0
+
+	raw, err := zipWrite.CreateRaw(header)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := raw.Write(append(salt, verifier...)); err != nil {
+		return nil, err
+	}
+
+	encrypt := &aesWriter{dst: raw, ctr: newWinzipCTR(block), mac: mac, count: 0, buf: nil}
+	writer := &encryptedEntry{header: header, encrypt: encrypt, comp: nopWriteCloser{encrypt}, count: 0, overhead: len(salt) + len(verifier)}
+
+	if method == zip.Deflate {
+		if writer.comp, err = flate.NewWriter(encrypt, flate.DefaultCompression); err != nil {
+			return nil, err
+		}
+	}
+
+	return writer, nil
+}
+
+type aesWriter struct {
+	dst   io.Writer
+	ctr   *winzipCTR
+	mac   hash.Hash
+	count uint64
+	buf   []byte
+}
+
+func (w *aesWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf[:0], p...)
+	w.ctr.XORKeyStream(w.buf, w.buf)
+	w.mac.Write(w.buf)
+
+	n, err := w.dst.Write(w.buf)
+	w.count += uint64(n) //nolint:gosec // n is never negative
+
+	return n, err
+}
+
+type encryptedEntry struct {
+	header   *zip.FileHeader
+	encrypt  *aesWriter
+	comp     io.WriteCloser
+	count    uint64
+	overhead int
+}
+
+func (w *encryptedEntry) Write(p []byte) (int, error) {
+	n, err := w.comp.Write(p)
+	w.count += uint64(n) //nolint:gosec // n is never negative
+
+	return n, err
+}
+
+func (w *encryptedEntry) Close() error {
+	if err := w.comp.Close(); err != nil {
+		return err
+	}
+
+	if _, err := w.encrypt.dst.Write(w.encrypt.mac.Sum(nil)[:aesAuthCodeSize]); err != nil {
+		return err
+	}
+
+	w.header.CompressedSize64 = uint64(w.overhead) + w.encrypt.count + aesAuthCodeSize //nolint:gosec // overhead is a few bytes
+	w.header.UncompressedSize64 = w.count
+
+	if w.header.CompressedSize64 > uint32max || w.header.UncompressedSize64 > uint32max {
+		w.header.CompressedSize = uint32max
+		w.header.UncompressedSize = uint32max
+		w.header.ReaderVersion = zipVersion45
+	} else {
+		w.header.CompressedSize = uint32(w.header.CompressedSize64)
+		w.header.UncompressedSize = uint32(w.header.UncompressedSize64)
+	}
+
+	return nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
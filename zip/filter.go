@@ -0,0 +1,185 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Extra fields from which archive/zip reads an exact modification time.
+const (
+	extraNTFS              = 0x000a
+	extraUnix              = 0x000d
+	extraExtendedTimestamp = 0x5455
+	extraInfoZipUnix       = 0x5855
+)
+
+type OverwritePolicy int
+
+const (
+	OverwriteAlways OverwritePolicy = iota
+	OverwriteNever
+	OverwriteIfNewer
+	OverwriteFail
+)
+
+type Remap struct {
+	From string
+	To   string
+}
+
+// Filter selects which entries are extracted and where they are written.
+//
+// Include and Exclude are slash-separated globs in which "**" matches any
+// number of path segments; a pattern that matches a directory also matches
+// everything below it. An entry is extracted when it passes Predicate and
+// Regex (if set), matches at least one Include pattern (if any) and matches
+// no Exclude pattern.
+//
+// Selected names are rewritten by dropping StripComponents leading segments
+// and then replacing the first matching Remap prefix.
+//
+// KeepModTimes gives extracted files the modification time stored in the
+// archive. Entries without an extended timestamp only store the local time
+// of whoever wrote them, which is read as this machine's local time, both
+// for KeepModTimes and for OverwriteIfNewer.
+type Filter struct {
+	Include         []string
+	Exclude         []string
+	Regex           *regexp.Regexp
+	Predicate       func(*zip.File) bool
+	StripComponents int
+	Remap           []Remap
+	Overwrite       OverwritePolicy
+	KeepModTimes    bool
+}
+
+func (f Filter) match(file *zip.File) bool {
+	if f.Predicate != nil && !f.Predicate(file) {
+		return false
+	}
+
+	if f.Regex != nil && !f.Regex.MatchString(file.Name) {
+		return false
+	}
+
+	if len(f.Include) != 0 && !matchAny(f.Include, file.Name) {
+		return false
+	}
+
+	return !matchAny(f.Exclude, file.Name)
+}
+
+func (f Filter) rewrite(name string) (string, bool) {
+	if f.StripComponents > 0 {
+		parts := strings.Split(strings.TrimSuffix(name, "/"), "/")
+		if len(parts) <= f.StripComponents {
+			return "", false
+		}
+
+		name = strings.Join(parts[f.StripComponents:], "/")
+	}
+
+	for _, remap := range f.Remap {
+		if strings.HasPrefix(name, remap.From) {
+			name = remap.To + strings.TrimPrefix(name, remap.From)
+			break
+		}
+	}
+
+	return name, name != ""
+}
+
+// modTime returns when file was last modified. archive/zip reads the MS-DOS
+// time, which has no time zone, as UTC when there is no NTFS, Unix or
+// extended timestamp, so that time is taken to be local instead.
+func modTime(file *zip.File) time.Time {
+	if hasExactTime(file.Extra) {
+		return file.Modified
+	}
+
+	m := file.Modified
+
+	return time.Date(m.Year(), m.Month(), m.Day(), m.Hour(), m.Minute(), m.Second(), 0, time.Local)
+}
+
+func hasExactTime(extra []byte) bool {
+	for len(extra) >= extraHeaderSize {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+
+		switch tag {
+		case extraNTFS, extraUnix, extraExtendedTimestamp, extraInfoZipUnix:
+			return true
+		}
+
+		if extraHeaderSize+size > len(extra) {
+			break
+		}
+
+		extra = extra[extraHeaderSize+size:]
+	}
+
+	return false
+}
+
+func matchAny(patterns []string, name string) bool {
+	segments := strings.Split(strings.Trim(name, "/"), "/")
+
+	for _, pattern := range patterns {
+		if matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), segments) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchSegments reports whether pattern matches name or one of its parent
+// directories.
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(name) == 0 {
+		return false
+	}
+
+	if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+		return false
+	}
+
+	return matchSegments(pattern[1:], name[1:])
+}
@@ -0,0 +1,111 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+)
+
+// ZipFS writes every file in fsys to w. Entry names are the slash-separated
+// fs.FS paths, so an embed.FS can be archived as-is.
+func ZipFS(fsys fs.FS, w io.Writer, opts ...Options) error {
+	return ZipFSWithMessenger(fsys, w, DefaultZipMessenger(), opts...)
+}
+
+func ZipFSWithMessenger(fsys fs.FS, w io.Writer, messenger Messenger, opts ...Options) error {
+	opt := assureOptions(opts...)
+	zipWrite := zip.NewWriter(w)
+
+	if err := addFS(zipWrite, fsys, messenger, opt); err != nil {
+		zipWrite.Close()
+		return err
+	}
+
+	return zipWrite.Close()
+}
+
+// OpenFS returns a read-only fs.FS over the archive in r, allowing files to be
+// read straight out of a bundle without extracting it.
+func OpenFS(r io.ReaderAt, size int64) (fs.FS, error) { //nolint:ireturn // fs.FS is the point
+	zipRead, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return zipRead, nil
+}
+
+func addFS(zipWrite *zip.Writer, fsys fs.FS, messenger Messenger, opt Options) error {
+	return fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+
+		header.Name = name
+		header.Method = opt.Method
+
+		zipCreate, err := createEntry(zipWrite, header, opt)
+		if err != nil {
+			return err
+		}
+
+		messenger.AddedFile(name)
+
+		file, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(zipCreate, file); err != nil {
+			return err
+		}
+
+		return zipCreate.Close()
+	})
+}
+
+func createEntry(zipWrite *zip.Writer, header *zip.FileHeader, opt Options) (io.WriteCloser, error) {
+	if opt.Password != "" {
+		return createEncrypted(zipWrite, header, opt)
+	}
+
+	zipCreate, err := zipWrite.CreateHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return nopWriteCloser{zipCreate}, nil
+}
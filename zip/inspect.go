@@ -0,0 +1,307 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ricochhet/minicommon/crypto"
+)
+
+// Entry describes a file or directory in an archive, as stored in its
+// central directory.
+type Entry struct {
+	Name             string
+	CompressedSize   uint64
+	UncompressedSize uint64
+	CRC32            uint32
+	Method           uint16
+	Modified         time.Time
+	Mode             fs.FileMode
+}
+
+// EntryError is an entry that Test could not read.
+type EntryError struct {
+	Name string
+	Err  error
+}
+
+func (e EntryError) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+func (e EntryError) Unwrap() error {
+	return e.Err
+}
+
+type diffEntry struct {
+	size uint64
+	crc  uint32
+	open func() (io.ReadCloser, error)
+}
+
+// List returns the entries of the archive at path, which may be split, in
+// the order of its central directory without decompressing them.
+func List(path string) ([]Entry, error) {
+	zipRead, closer, err := openArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	entries := make([]Entry, 0, len(zipRead.File))
+
+	for _, file := range zipRead.File {
+		entries = append(entries, Entry{
+			Name:             file.Name,
+			CompressedSize:   file.CompressedSize64,
+			UncompressedSize: file.UncompressedSize64,
+			CRC32:            file.CRC32,
+			Method:           file.Method,
+			Modified:         file.Modified,
+			Mode:             file.Mode(),
+		})
+	}
+
+	return entries, nil
+}
+
+// Test decompresses every entry in the archive and returns one EntryError for
+// each entry that could not be read or failed its CRC check.
+func Test(path string, opts ...Options) ([]EntryError, error) {
+	opt := assureOptions(opts...)
+
+	zipRead, closer, err := openArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	var failed []EntryError
+
+	for _, file := range zipRead.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		if err := testEntry(file, opt.Password); err != nil {
+			failed = append(failed, EntryError{Name: file.Name, Err: err})
+		}
+	}
+
+	return failed, nil
+}
+
+func testEntry(file *zip.File, password string) error {
+	readclose, err := openEntry(file, password)
+	if err != nil {
+		return err
+	}
+	defer readclose.Close()
+
+	_, err = io.Copy(io.Discard, readclose)
+
+	return err
+}
+
+// Diff compares the archive at pathA with the archive or directory at pathB.
+// Entries are compared by size and CRC-32; when verifyHashes is set, entries
+// whose CRCs match are additionally compared by SHA-256. Encrypted entries
+// are read with the password of opts, and AE-2 entries, which store no CRC,
+// are decrypted to compute one.
+func Diff(pathA, pathB string, verifyHashes bool, opts ...Options) ([]crypto.DiffData, error) {
+	opt := assureOptions(opts...)
+
+	zipA, closerA, err := openArchive(pathA)
+	if err != nil {
+		return nil, err
+	}
+	defer closerA.Close()
+
+	entriesA, err := indexArchive(zipA, opt.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	entriesB, closer, err := indexPath(pathB, opt.Password)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	hashesA := make(map[string]string, len(entriesA))
+	hashesB := make(map[string]string, len(entriesB))
+
+	for name, entryB := range entriesB {
+		hashesB[name] = crcString(entryB)
+	}
+
+	for name, entryA := range entriesA {
+		hashesA[name] = crcString(entryA)
+
+		entryB, ok := entriesB[name]
+		if !ok || !verifyHashes || hashesA[name] != hashesB[name] {
+			continue
+		}
+
+		if hashesA[name], err = sha256Entry(entryA); err != nil {
+			return nil, err
+		}
+
+		if hashesB[name], err = sha256Entry(entryB); err != nil {
+			return nil, err
+		}
+	}
+
+	return crypto.DiffDirectory(hashesA, hashesB, pathA, pathB), nil
+}
+
+func indexPath(path, password string) (map[string]diffEntry, io.Closer, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if info.IsDir() {
+		entries, err := indexDirectory(path)
+		return entries, io.NopCloser(nil), err
+	}
+
+	zipRead, closer, err := openArchive(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries, err := indexArchive(zipRead, password)
+	if err != nil {
+		closer.Close()
+		return nil, nil, err
+	}
+
+	return entries, closer, nil
+}
+
+func indexArchive(zipRead *zip.Reader, password string) (map[string]diffEntry, error) {
+	entries := make(map[string]diffEntry, len(zipRead.File))
+
+	for _, file := range zipRead.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		entry := diffEntry{
+			size: file.UncompressedSize64,
+			crc:  file.CRC32,
+			open: func() (io.ReadCloser, error) { return openEntry(file, password) },
+		}
+
+		if extra, err := parseAESExtra(file.Extra); err == nil && isEncrypted(file) && extra.version == aesVersion2 {
+			if entry.crc, err = crcEntry(entry); err != nil {
+				return nil, EntryError{Name: file.Name, Err: err}
+			}
+		}
+
+		entries[file.Name] = entry
+	}
+
+	return entries, nil
+}
+
+func indexDirectory(dir string) (map[string]diffEntry, error) {
+	entries := make(map[string]diffEntry)
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		open := func() (io.ReadCloser, error) { return os.Open(path) }
+
+		file, err := open()
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		hash := crc32.NewIEEE()
+
+		size, err := io.Copy(hash, file)
+		if err != nil {
+			return err
+		}
+
+		entries[filepath.ToSlash(rel)] = diffEntry{size: uint64(size), crc: hash.Sum32(), open: open}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+func crcString(entry diffEntry) string {
+	return fmt.Sprintf("%08x-%d", entry.crc, entry.size)
+}
+
+func crcEntry(entry diffEntry) (uint32, error) {
+	readclose, err := entry.open()
+	if err != nil {
+		return 0, err
+	}
+	defer readclose.Close()
+
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, readclose); err != nil {
+		return 0, err
+	}
+
+	return hash.Sum32(), nil
+}
+
+func sha256Entry(entry diffEntry) (string, error) {
+	readclose, err := entry.open()
+	if err != nil {
+		return "", err
+	}
+	defer readclose.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, readclose); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,229 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ricochhet/minicommon/filesystem"
+)
+
+var (
+	ErrLimitExceeded = errors.New("extraction limit exceeded")
+	errNestedTarget  = errors.New("nested archive target is not a directory")
+)
+
+// nestedMagicSize is how many leading bytes NestedFormat.Match is given.
+const nestedMagicSize = 16
+
+//nolint:gochecknoglobals // wontfix
+var (
+	zipMagic      = []byte{'P', 'K', 0x03, 0x04}
+	zipEmptyMagic = []byte{'P', 'K', 0x05, 0x06}
+)
+
+// NestedFormat is an archive format other than zip that is extracted when it
+// is nested in a zip archive, see Options. Match is given the first bytes of
+// an entry, and Walk calls fn for every entry of the archive at path, in
+// order, with a reader for its contents. sevenzip.NestedFormat implements it
+// for 7z archives.
+type NestedFormat interface {
+	Match(magic []byte) bool
+	Walk(path string, fn func(name string, info fs.FileInfo, contents io.Reader) error) error
+}
+
+// extractor holds the state shared by every nesting level of one extraction,
+// so that the file and size limits apply to the extraction as a whole.
+type extractor struct {
+	filter    Filter
+	messenger Messenger
+	opt       Options
+	files     int
+	size      uint64
+}
+
+func newExtractor(filter Filter, messenger Messenger, opt Options) *extractor {
+	return &extractor{filter: filter, messenger: messenger, opt: opt, files: 0, size: 0}
+}
+
+func (e *extractor) addFile() error {
+	e.files++
+
+	if e.opt.MaxFiles > 0 && e.files > e.opt.MaxFiles {
+		return fmt.Errorf("%w: more than %d files", ErrLimitExceeded, e.opt.MaxFiles)
+	}
+
+	return nil
+}
+
+func (e *extractor) addSize(n uint64) error {
+	e.size += n
+
+	if e.opt.MaxSize > 0 && e.size > e.opt.MaxSize {
+		return fmt.Errorf("%w: more than %d bytes", ErrLimitExceeded, e.opt.MaxSize)
+	}
+
+	return nil
+}
+
+// budgetReader charges every byte read against the extractor's size limit.
+type budgetReader struct {
+	src       io.Reader
+	extractor *extractor
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if n > 0 {
+		if limitErr := r.extractor.addSize(uint64(n)); limitErr != nil {
+			return n, limitErr
+		}
+	}
+
+	return n, err
+}
+
+// detectArchive returns whether the file at path is a zip archive, or the
+// nested format it is in, if any.
+func (e *extractor) detectArchive(path string) (bool, NestedFormat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, nil, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, nestedMagicSize)
+
+	n, err := io.ReadFull(file, magic)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return false, nil, err
+	}
+
+	magic = magic[:n]
+
+	if bytes.HasPrefix(magic, zipMagic) || bytes.HasPrefix(magic, zipEmptyMagic) {
+		return len(e.opt.NestedExtensions) == 0 || slices.Contains(e.opt.NestedExtensions, strings.ToLower(filepath.Ext(path))), nil, nil
+	}
+
+	for _, format := range e.opt.NestedFormats {
+		if format.Match(magic) {
+			return false, format, nil
+		}
+	}
+
+	return false, nil, nil
+}
+
+func nestedDir(path string) string {
+	if ext := filepath.Ext(path); ext != "" {
+		return path[:len(path)-len(ext)]
+	}
+
+	return path + ".d"
+}
+
+// extractNested extracts the archive at path, if it is one, into a directory
+// next to it and removes the archive unless KeepNested is set.
+func (e *extractor) extractNested(path string, depth int, nesting []string) error {
+	isZip, format, err := e.detectArchive(path)
+	if err != nil || (!isZip && format == nil) {
+		return err
+	}
+
+	outPath := nestedDir(path)
+
+	// The directory may be left from an earlier extraction, but must not be
+	// a file of the archive.
+	if info, err := os.Lstat(outPath); err == nil && !info.IsDir() {
+		return fmt.Errorf("%w: %s", errNestedTarget, outPath)
+	}
+
+	if e.messenger.Nested != nil {
+		e.messenger.Nested(nesting)
+	}
+
+	if isZip {
+		err = e.extractNestedZip(path, outPath, depth, nesting)
+	} else {
+		err = e.extractNestedFormat(format, path, outPath, depth, nesting)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if e.opt.KeepNested {
+		return nil
+	}
+
+	return os.Remove(path)
+}
+
+func (e *extractor) extractNestedZip(path, outPath string, depth int, nesting []string) error {
+	zipRead, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zipRead.Close()
+
+	//nolint:exhaustruct // nested archives are extracted whole
+	return e.extract(&zipRead.Reader, outPath, Filter{Overwrite: e.filter.Overwrite, KeepModTimes: e.filter.KeepModTimes}, depth, nesting)
+}
+
+// extractNestedFormat writes the entries of another format as the format
+// decodes them, so the limits apply before anything exceeding them is
+// written.
+func (e *extractor) extractNestedFormat(format NestedFormat, path, outPath string, depth int, nesting []string) error {
+	return format.Walk(path, func(name string, info fs.FileInfo, contents io.Reader) error {
+		destPath, err := filesystem.SecureJoin(outPath, name)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			e.messenger.AddedFile(destPath)
+			return os.MkdirAll(destPath, os.ModePerm)
+		}
+
+		if write, err := shouldWrite(destPath, info.ModTime(), e.filter.Overwrite); err != nil || !write {
+			return err
+		}
+
+		e.messenger.AddedFile(destPath)
+
+		if err := e.writeFile(contents, destPath, info.ModTime()); err != nil {
+			return err
+		}
+
+		if depth >= e.opt.NestedDepth {
+			return nil
+		}
+
+		return e.extractNested(destPath, depth+1, append(nesting[:len(nesting):len(nesting)], name))
+	})
+}
@@ -0,0 +1,67 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zip
+
+import "archive/zip"
+
+// Options configures both writing and extraction. NestedDepth is how many
+// levels of archives inside the archive are extracted in place, zip archives
+// and those of the NestedFormats, and MaxFiles and MaxSize bound the
+// extraction as a whole. VolumeSize splits the archive
+// written by Zip into volumes of at most that many bytes. Zero disables each
+// of them. Nested zip archives are found by their signature whatever their
+// name; NestedExtensions, if set, limits them to entries with one of those
+// extensions, "" for none, to leave documents and Java archives whole.
+type Options struct {
+	Method           uint16
+	Password         string
+	Encryption       Encryption
+	NestedDepth      int
+	KeepNested       bool
+	NestedFormats    []NestedFormat
+	NestedExtensions []string
+	MaxFiles         int
+	MaxSize          uint64
+	VolumeSize       int64
+}
+
+func getDefaultOptions() Options {
+	return Options{
+		Method:           zip.Deflate,
+		Password:         "",
+		Encryption:       AES256,
+		NestedDepth:      0,
+		KeepNested:       false,
+		NestedFormats:    nil,
+		NestedExtensions: nil,
+		MaxFiles:         0,
+		MaxSize:          0,
+		VolumeSize:       0,
+	}
+}
+
+func assureOptions(opts ...Options) Options {
+	defopt := getDefaultOptions()
+
+	if len(opts) == 0 {
+		return defopt
+	}
+
+	return opts[0]
+}
@@ -0,0 +1,128 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ricochhet/minicommon/readwrite"
+)
+
+// SFX is an archive appended to an executable. Offset is where the archive
+// data starts within the executable.
+type SFX struct {
+	*zip.Reader
+	Offset int64
+	file   *os.File
+}
+
+func (s *SFX) Close() error {
+	return s.file.Close()
+}
+
+// BuildSFX writes the executable at stubPath followed by an archive of srcDir
+// to outPath. Central directory offsets are relative to the start of outPath,
+// so the result is also readable by ordinary zip tools.
+func BuildSFX(stubPath, srcDir, outPath string, opts ...Options) error {
+	return BuildSFXWithMessenger(stubPath, srcDir, outPath, DefaultZipMessenger(), opts...)
+}
+
+func BuildSFXWithMessenger(stubPath, srcDir, outPath string, messenger Messenger, opts ...Options) error {
+	stub, err := os.Open(stubPath)
+	if err != nil {
+		return err
+	}
+	defer stub.Close()
+
+	info, err := stub.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0o700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(outPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	offset, err := io.Copy(file, stub)
+	if err != nil {
+		return err
+	}
+
+	zipWrite := zip.NewWriter(file)
+	zipWrite.SetOffset(offset)
+
+	if err := addFS(zipWrite, os.DirFS(srcDir), messenger, assureOptions(opts...)); err != nil {
+		zipWrite.Close()
+		return err
+	}
+
+	if err := zipWrite.Close(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// OpenSelf opens the archive appended to the running executable.
+func OpenSelf() (*SFX, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenSFX(path)
+}
+
+// OpenSFX opens the archive appended to the executable at path. The overlay is
+// located from the PE or ELF headers; for other formats the archive is found
+// from the end of the file alone.
+func OpenSFX(path string) (*SFX, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	offset, err := readwrite.OverlayOffset(file)
+	if err != nil || offset > info.Size() {
+		offset = 0
+	}
+
+	zipRead, err := zip.NewReader(io.NewSectionReader(file, offset, info.Size()-offset), info.Size()-offset)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &SFX{Reader: zipRead, Offset: offset, file: file}, nil
+}
@@ -0,0 +1,831 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Split archives are described in section 8 of the PKWARE APPNOTE. Every
+// volume but the last is named .z01, .z02 and so on, and offsets in the
+// central directory are relative to the start of the volume they point into.
+const (
+	sigSplit          = 0x08074b50
+	sigSplitSingle    = 0x30304b50
+	sigCentralDir     = 0x02014b50
+	sigDirectoryEnd   = 0x06054b50
+	sigZip64End       = 0x06064b50
+	sigZip64Locator   = 0x07064b50
+	centralDirLen     = 46
+	directoryEndLen   = 22
+	zip64EndLen       = 56
+	zip64LocatorLen   = 20
+	zip64ExtraID      = 0x0001
+	uint16max         = 0xffff
+	maxCommentLen     = 0xffff
+	splitSignatureLen = 4
+)
+
+//nolint:gochecknoglobals // wontfix
+var volumePattern = regexp.MustCompile(`^\d{2,}$`)
+
+var (
+	errInvalidVolumeSize = errors.New("volume size is too small")
+	errMissingVolume     = errors.New("split archive is missing a volume")
+	errNoDirectoryEnd    = errors.New("end of central directory not found")
+	errInvalidCentralDir = errors.New("invalid central directory")
+)
+
+// centralDirEntry is a central directory record with its zip64 fields
+// resolved, so that its disk and offset can be rewritten.
+type centralDirEntry struct {
+	header       []byte
+	name         []byte
+	extra        []byte
+	comment      []byte
+	uncompressed uint64
+	compressed   uint64
+	offset       uint64
+	disk         uint32
+}
+
+type zip64Locator struct {
+	disk   uint32
+	offset uint64
+	disks  uint32
+}
+
+type directoryEnd struct {
+	disk      uint32
+	dirDisk   uint32
+	records   uint64
+	dirSize   uint64
+	dirOffset uint64
+	comment   []byte
+}
+
+func volumeName(path string, disk int) string {
+	return fmt.Sprintf("%s.z%02d", strings.TrimSuffix(path, filepath.Ext(path)), disk+1)
+}
+
+// removeVolumes removes the .z01, .z02 ... volumes next to path from the
+// volume numbered disk+1 on, which an earlier archive may have left behind.
+// Only volumes of a .zip path are removed, since files of other names may
+// merely look like volumes.
+func removeVolumes(path string, disk int) error {
+	if !strings.EqualFold(filepath.Ext(path), ".zip") {
+		return nil
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	prefix := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ".z"
+
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || !volumePattern.MatchString(suffix) {
+			continue
+		}
+
+		if number, err := strconv.Atoi(suffix); err == nil && number > disk {
+			if err := os.Remove(filepath.Join(filepath.Dir(path), entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// diskCount reads how many volumes the archive whose last volume is path
+// spans from its end of central directory record. Volumes on disk that the
+// record does not count are left over from another archive.
+func diskCount(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	end, locator, err := readDirectoryEnd(file, info.Size())
+	if err != nil {
+		return 0, err
+	}
+
+	if locator != nil {
+		return max(int(locator.disks), 1), nil
+	}
+
+	return int(end.disk) + 1, nil
+}
+
+func parseCentralDir(buf []byte) ([]centralDirEntry, error) {
+	var entries []centralDirEntry
+
+	for len(buf) >= centralDirLen && binary.LittleEndian.Uint32(buf) == sigCentralDir {
+		nameLen := int(binary.LittleEndian.Uint16(buf[28:]))
+		extraLen := int(binary.LittleEndian.Uint16(buf[30:]))
+		commentLen := int(binary.LittleEndian.Uint16(buf[32:]))
+
+		end := centralDirLen + nameLen + extraLen + commentLen
+		if end > len(buf) {
+			return nil, errInvalidCentralDir
+		}
+
+		entry := centralDirEntry{
+			header:       append([]byte(nil), buf[:centralDirLen]...),
+			name:         buf[centralDirLen : centralDirLen+nameLen],
+			extra:        buf[centralDirLen+nameLen : centralDirLen+nameLen+extraLen],
+			comment:      buf[centralDirLen+nameLen+extraLen : end],
+			compressed:   uint64(binary.LittleEndian.Uint32(buf[20:])),
+			uncompressed: uint64(binary.LittleEndian.Uint32(buf[24:])),
+			disk:         uint32(binary.LittleEndian.Uint16(buf[34:])),
+			offset:       uint64(binary.LittleEndian.Uint32(buf[42:])),
+		}
+
+		entry.readZip64()
+		entries = append(entries, entry)
+		buf = buf[end:]
+	}
+
+	return entries, nil
+}
+
+func (e *centralDirEntry) readZip64() {
+	extra := e.extra
+
+	for len(extra) >= extraHeaderSize {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[extraHeaderSize:]
+
+		if size > len(extra) {
+			return
+		}
+
+		if tag == zip64ExtraID {
+			field := extra[:size]
+
+			for _, value := range []*uint64{&e.uncompressed, &e.compressed, &e.offset} {
+				if *value == uint32max && len(field) >= 8 {
+					*value = binary.LittleEndian.Uint64(field)
+					field = field[8:]
+				}
+			}
+
+			if e.disk == uint16max && len(field) >= 4 {
+				e.disk = binary.LittleEndian.Uint32(field)
+			}
+
+			return
+		}
+
+		extra = extra[size:]
+	}
+}
+
+// encode writes the record with zip64 fields only where a value does not fit
+// in its regular field.
+func (e *centralDirEntry) encode(w *bytes.Buffer) {
+	var zip64 []byte
+
+	header := append([]byte(nil), e.header...)
+
+	if e.uncompressed >= uint32max || e.compressed >= uint32max {
+		binary.LittleEndian.PutUint32(header[20:], uint32max)
+		binary.LittleEndian.PutUint32(header[24:], uint32max)
+		zip64 = binary.LittleEndian.AppendUint64(zip64, e.uncompressed)
+		zip64 = binary.LittleEndian.AppendUint64(zip64, e.compressed)
+	} else {
+		binary.LittleEndian.PutUint32(header[20:], uint32(e.compressed))
+		binary.LittleEndian.PutUint32(header[24:], uint32(e.uncompressed))
+	}
+
+	if e.offset >= uint32max {
+		binary.LittleEndian.PutUint32(header[42:], uint32max)
+		zip64 = binary.LittleEndian.AppendUint64(zip64, e.offset)
+	} else {
+		binary.LittleEndian.PutUint32(header[42:], uint32(e.offset))
+	}
+
+	if e.disk >= uint16max {
+		binary.LittleEndian.PutUint16(header[34:], uint16max)
+		zip64 = binary.LittleEndian.AppendUint32(zip64, e.disk)
+	} else {
+		binary.LittleEndian.PutUint16(header[34:], uint16(e.disk))
+	}
+
+	extra := withoutExtra(e.extra, zip64ExtraID)
+	if len(zip64) != 0 {
+		extra = binary.LittleEndian.AppendUint16(extra, zip64ExtraID)
+		extra = binary.LittleEndian.AppendUint16(extra, uint16(len(zip64)))
+		extra = append(extra, zip64...)
+	}
+
+	binary.LittleEndian.PutUint16(header[30:], uint16(len(extra))) //nolint:gosec // extra fields are bounded by uint16
+
+	w.Write(header)
+	w.Write(e.name)
+	w.Write(extra)
+	w.Write(e.comment)
+}
+
+func withoutExtra(extra []byte, id uint16) []byte {
+	var out []byte
+
+	for len(extra) >= extraHeaderSize {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+
+		if extraHeaderSize+size > len(extra) {
+			break
+		}
+
+		if tag != id {
+			out = append(out, extra[:extraHeaderSize+size]...)
+		}
+
+		extra = extra[extraHeaderSize+size:]
+	}
+
+	return out
+}
+
+// readDirectoryEnd finds the end of central directory record in the last
+// size bytes of r, along with the location of the zip64 end record if the
+// archive has one.
+func readDirectoryEnd(r io.ReaderAt, size int64) (directoryEnd, *zip64Locator, error) {
+	var end directoryEnd
+
+	tailLen := min(size, directoryEndLen+maxCommentLen)
+	tail := make([]byte, tailLen)
+
+	if _, err := r.ReadAt(tail, size-tailLen); err != nil {
+		return end, nil, err
+	}
+
+	pos := -1
+
+	for i := len(tail) - directoryEndLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == sigDirectoryEnd {
+			pos = i
+			break
+		}
+	}
+
+	if pos < 0 {
+		return end, nil, errNoDirectoryEnd
+	}
+
+	record := tail[pos:]
+	end.disk = uint32(binary.LittleEndian.Uint16(record[4:]))
+	end.dirDisk = uint32(binary.LittleEndian.Uint16(record[6:]))
+	end.records = uint64(binary.LittleEndian.Uint16(record[10:]))
+	end.dirSize = uint64(binary.LittleEndian.Uint32(record[12:]))
+	end.dirOffset = uint64(binary.LittleEndian.Uint32(record[16:]))
+	end.comment = record[directoryEndLen:min(len(record), directoryEndLen+int(binary.LittleEndian.Uint16(record[20:])))]
+
+	if pos < zip64LocatorLen || binary.LittleEndian.Uint32(tail[pos-zip64LocatorLen:]) != sigZip64Locator {
+		return end, nil, nil
+	}
+
+	locator := tail[pos-zip64LocatorLen:]
+
+	return end, &zip64Locator{
+		disk:   binary.LittleEndian.Uint32(locator[4:]),
+		offset: binary.LittleEndian.Uint64(locator[8:]),
+		disks:  binary.LittleEndian.Uint32(locator[16:]),
+	}, nil
+}
+
+// readZip64End reads the zip64 end record at offset into end.
+func readZip64End(r io.ReaderAt, end directoryEnd, offset int64) (directoryEnd, error) {
+	record := make([]byte, zip64EndLen)
+	if _, err := r.ReadAt(record, offset); err != nil {
+		return end, err
+	}
+
+	if binary.LittleEndian.Uint32(record) != sigZip64End {
+		return end, errNoDirectoryEnd
+	}
+
+	end.disk = binary.LittleEndian.Uint32(record[16:])
+	end.dirDisk = binary.LittleEndian.Uint32(record[20:])
+	end.records = binary.LittleEndian.Uint64(record[32:])
+	end.dirSize = binary.LittleEndian.Uint64(record[40:])
+	end.dirOffset = binary.LittleEndian.Uint64(record[48:])
+
+	return end, nil
+}
+
+// writeDirectoryEnd writes the end of central directory record, preceded by
+// the zip64 record and locator when any value does not fit the regular one.
+// offset is where the records start, relative to the start of disk end.disk.
+func writeDirectoryEnd(w *bytes.Buffer, end directoryEnd, offset uint64) {
+	if end.records >= uint16max || end.dirSize >= uint32max || end.dirOffset >= uint32max || end.disk >= uint16max {
+		record := make([]byte, zip64EndLen)
+		binary.LittleEndian.PutUint32(record, sigZip64End)
+		binary.LittleEndian.PutUint64(record[4:], zip64EndLen-12) //nolint:mnd // size excludes the leading 12 bytes
+		binary.LittleEndian.PutUint16(record[12:], zipVersion45)
+		binary.LittleEndian.PutUint16(record[14:], zipVersion45)
+		binary.LittleEndian.PutUint32(record[16:], end.disk)
+		binary.LittleEndian.PutUint32(record[20:], end.dirDisk)
+		binary.LittleEndian.PutUint64(record[24:], end.records)
+		binary.LittleEndian.PutUint64(record[32:], end.records)
+		binary.LittleEndian.PutUint64(record[40:], end.dirSize)
+		binary.LittleEndian.PutUint64(record[48:], end.dirOffset)
+		w.Write(record)
+
+		locator := make([]byte, zip64LocatorLen)
+		binary.LittleEndian.PutUint32(locator, sigZip64Locator)
+		binary.LittleEndian.PutUint32(locator[4:], end.disk)
+		binary.LittleEndian.PutUint64(locator[8:], offset)
+		binary.LittleEndian.PutUint32(locator[16:], end.disk+1)
+		w.Write(locator)
+	}
+
+	record := make([]byte, directoryEndLen)
+	binary.LittleEndian.PutUint32(record, sigDirectoryEnd)
+	binary.LittleEndian.PutUint16(record[4:], uint16(min(end.disk, uint16max)))
+	binary.LittleEndian.PutUint16(record[6:], uint16(min(end.dirDisk, uint16max)))
+	binary.LittleEndian.PutUint16(record[8:], uint16(min(end.records, uint16max)))
+	binary.LittleEndian.PutUint16(record[10:], uint16(min(end.records, uint16max)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(min(end.dirSize, uint32max)))
+	binary.LittleEndian.PutUint32(record[16:], uint32(min(end.dirOffset, uint32max)))
+	binary.LittleEndian.PutUint16(record[20:], uint16(len(end.comment))) //nolint:gosec // comments are bounded by uint16
+	w.Write(record)
+	w.Write(end.comment)
+}
+
+// Volumes presents the volumes of a split archive as one io.ReaderAt. The
+// central directory is rewritten to use offsets from the start of the first
+// volume, so the result can be read by archive/zip.
+type Volumes struct {
+	files  []*os.File
+	starts []int64
+	data   int64
+	tail   []byte
+}
+
+// OpenVolumes opens the split archive whose last volume is path, along with
+// as many .z01, .z02 ... volumes as its end of central directory record
+// counts. An archive that was not split is opened as a single volume.
+func OpenVolumes(path string) (*Volumes, error) {
+	count, err := diskCount(path)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, count)
+
+	for disk := range count - 1 {
+		paths = append(paths, volumeName(path, disk))
+	}
+
+	volumes := &Volumes{files: nil, starts: nil, data: 0, tail: nil}
+
+	for _, name := range append(paths, path) {
+		file, err := os.Open(name)
+		if errors.Is(err, os.ErrNotExist) {
+			volumes.Close()
+			return nil, fmt.Errorf("%w: %s", errMissingVolume, name)
+		} else if err != nil {
+			volumes.Close()
+			return nil, err
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			volumes.Close()
+
+			return nil, err
+		}
+
+		volumes.files = append(volumes.files, file)
+		volumes.starts = append(volumes.starts, volumes.data)
+		volumes.data += info.Size()
+	}
+
+	if err := volumes.relocate(); err != nil {
+		volumes.Close()
+		return nil, err
+	}
+
+	return volumes, nil
+}
+
+func (v *Volumes) relocate() error {
+	last := len(v.files) - 1
+	lastSize := v.data - v.starts[last]
+
+	end, locator, err := readDirectoryEnd(io.NewSectionReader(v, v.starts[last], lastSize), lastSize)
+	if err != nil {
+		return err
+	}
+
+	if locator != nil {
+		if int(locator.disk) > last {
+			return errMissingVolume
+		}
+
+		if end, err = readZip64End(v, end, v.starts[locator.disk]+int64(locator.offset)); err != nil { //nolint:gosec // offsets fit the archive
+			return err
+		}
+	}
+
+	if int(end.disk) != last || int(end.dirDisk) > last {
+		return fmt.Errorf("%w: expected %d volumes, found %d", errMissingVolume, end.disk+1, len(v.files))
+	}
+
+	dir := make([]byte, end.dirSize)
+	if _, err := v.ReadAt(dir, v.starts[end.dirDisk]+int64(end.dirOffset)); err != nil { //nolint:gosec // offsets fit the archive
+		return err
+	}
+
+	entries, err := parseCentralDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var tail bytes.Buffer
+
+	for _, entry := range entries {
+		if int(entry.disk) > last {
+			return errMissingVolume
+		}
+
+		entry.offset += uint64(v.starts[entry.disk]) //nolint:gosec // starts are never negative
+		entry.disk = 0
+		entry.encode(&tail)
+	}
+
+	writeDirectoryEnd(&tail, directoryEnd{
+		disk:      0,
+		dirDisk:   0,
+		records:   uint64(len(entries)),
+		dirSize:   uint64(tail.Len()),
+		dirOffset: uint64(v.data), //nolint:gosec // sizes are never negative
+		comment:   end.comment,
+	}, uint64(v.data+int64(tail.Len()))) //nolint:gosec // sizes are never negative
+
+	v.tail = tail.Bytes()
+
+	return nil
+}
+
+func (v *Volumes) Size() int64 {
+	return v.data + int64(len(v.tail))
+}
+
+func (v *Volumes) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+
+	for len(p) > 0 {
+		if off >= v.data {
+			if off-v.data >= int64(len(v.tail)) {
+				return read, io.EOF
+			}
+
+			n := copy(p, v.tail[off-v.data:])
+			read += n
+
+			if n < len(p) {
+				return read, io.EOF
+			}
+
+			return read, nil
+		}
+
+		disk := sort.Search(len(v.starts), func(i int) bool { return v.starts[i] > off }) - 1
+		end := v.data
+
+		if disk+1 < len(v.starts) {
+			end = v.starts[disk+1]
+		}
+
+		chunk := p[:min(int64(len(p)), end-off)]
+
+		n, err := v.files[disk].ReadAt(chunk, off-v.starts[disk])
+		read += n
+
+		if err != nil && !(errors.Is(err, io.EOF) && n == len(chunk)) {
+			return read, err
+		}
+
+		p = p[n:]
+		off += int64(n)
+	}
+
+	return read, nil
+}
+
+func (v *Volumes) Close() error {
+	var err error
+
+	for _, file := range v.files {
+		err = errors.Join(err, file.Close())
+	}
+
+	return err
+}
+
+// openArchive opens path as a zip reader, transparently joining split volumes
+// when its end of central directory record says it has any.
+func openArchive(path string) (*zip.Reader, io.Closer, error) {
+	if count, err := diskCount(path); err != nil || count == 1 {
+		zipRead, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return &zipRead.Reader, zipRead, nil
+	}
+
+	volumes, err := OpenVolumes(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	zipRead, err := zip.NewReader(volumes, volumes.Size())
+	if err != nil {
+		volumes.Close()
+		return nil, nil, err
+	}
+
+	return zipRead, volumes, nil
+}
+
+// volumeWriter splits everything written to it across volumes of at most
+// size bytes, named like volumeName, and records where each volume starts.
+type volumeWriter struct {
+	path    string
+	size    int64
+	file    *os.File
+	written int64
+	starts  []int64
+	total   int64
+	capture *bytes.Buffer
+}
+
+func newVolumeWriter(path string, size int64) (*volumeWriter, error) {
+	if size < directoryEndLen+zip64EndLen+zip64LocatorLen {
+		return nil, errInvalidVolumeSize
+	}
+
+	writer := &volumeWriter{path: path, size: size, file: nil, written: 0, starts: nil, total: 0, capture: nil}
+
+	if err := writer.next(); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+func (w *volumeWriter) disk() int {
+	return len(w.starts) - 1
+}
+
+func (w *volumeWriter) next() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Create(volumeName(w.path, len(w.starts)))
+	if err != nil {
+		return err
+	}
+
+	w.file = file
+	w.written = 0
+	w.starts = append(w.starts, w.total)
+
+	return nil
+}
+
+func (w *volumeWriter) Write(p []byte) (int, error) {
+	if w.capture != nil {
+		return w.capture.Write(p)
+	}
+
+	written := 0
+
+	for len(p) > 0 {
+		if w.written == w.size {
+			if err := w.next(); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := w.file.Write(p[:min(int64(len(p)), w.size-w.written)])
+		written += n
+		w.written += int64(n)
+		w.total += int64(n)
+		p = p[n:]
+
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// finish rewrites the central directory that archive/zip produced into
+// capture with per-volume offsets, writes it to the last volume and renames
+// that volume to path.
+func (w *volumeWriter) finish() error {
+	captured := w.capture.Bytes()
+	w.capture = nil
+
+	end, locator, err := readDirectoryEnd(bytes.NewReader(captured), int64(len(captured)))
+	if err != nil {
+		return err
+	}
+
+	if locator != nil {
+		if end, err = readZip64End(bytes.NewReader(captured), end, int64(locator.offset)-w.total); err != nil { //nolint:gosec // offsets fit the archive
+			return err
+		}
+	}
+
+	// The data descriptor of the last entry is only written once the archive
+	// is closed, so it precedes the central directory in captured.
+	dirStart := int64(end.dirOffset) - w.total //nolint:gosec // offsets fit the archive
+	if dirStart < 0 || dirStart > int64(len(captured)) {
+		return errInvalidCentralDir
+	}
+
+	if _, err := w.Write(captured[:dirStart]); err != nil {
+		return err
+	}
+
+	entries, err := parseCentralDir(captured[dirStart:])
+	if err != nil {
+		return err
+	}
+
+	var dir bytes.Buffer
+
+	for _, entry := range entries {
+		disk := sort.Search(len(w.starts), func(i int) bool { return w.starts[i] > int64(entry.offset) }) - 1 //nolint:gosec // offsets fit the archive
+		entry.offset -= uint64(w.starts[disk])                                                                //nolint:gosec // starts are never negative
+		entry.disk = uint32(disk)                                                                             //nolint:gosec // disk counts are small
+		entry.encode(&dir)
+	}
+
+	// The central directory starts a new volume when it would not fit with
+	// the end records in this one, but one that does not fit any volume
+	// spans several.
+	reserve := int64(dir.Len() + directoryEndLen + zip64EndLen + zip64LocatorLen)
+	if w.written == w.size || (w.written > 0 && w.written+reserve > w.size && reserve <= w.size) {
+		if err := w.next(); err != nil {
+			return err
+		}
+	}
+
+	dirDisk, dirOffset := w.disk(), w.written
+
+	if _, err := w.Write(dir.Bytes()); err != nil {
+		return err
+	}
+
+	return w.writeEnd(dirDisk, dirOffset, dir.Len(), len(entries))
+}
+
+// writeEnd writes the end records, which must not be split, to the last
+// volume and renames it to path.
+func (w *volumeWriter) writeEnd(dirDisk int, dirOffset int64, dirSize, records int) error {
+	var tail bytes.Buffer
+
+	for {
+		tail.Reset()
+		writeDirectoryEnd(&tail, directoryEnd{
+			disk:      uint32(w.disk()),  //nolint:gosec // disk counts are small
+			dirDisk:   uint32(dirDisk),   //nolint:gosec // disk counts are small
+			records:   uint64(records),   //nolint:gosec // never negative
+			dirSize:   uint64(dirSize),   //nolint:gosec // never negative
+			dirOffset: uint64(dirOffset), //nolint:gosec // sizes are never negative
+			comment:   nil,
+		}, uint64(w.written)) //nolint:gosec // sizes are never negative
+
+		if w.written+int64(tail.Len()) <= w.size {
+			break
+		}
+
+		if err := w.next(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.Write(tail.Bytes()); err != nil {
+		return err
+	}
+
+	if w.disk() == 0 {
+		if err := w.markSingleVolume(); err != nil {
+			return err
+		}
+	}
+
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(volumeName(w.path, w.disk()), w.path)
+}
+
+// markSingleVolume replaces the split signature of an archive that ended up
+// fitting in one volume, as the APPNOTE requires.
+func (w *volumeWriter) markSingleVolume() error {
+	signature := binary.LittleEndian.AppendUint32(nil, sigSplitSingle)
+	_, err := w.file.WriteAt(signature, 0)
+
+	return err
+}
+
+func (w *volumeWriter) abort() {
+	if w.file != nil {
+		w.file.Close()
+	}
+
+	for disk := range w.starts {
+		os.Remove(volumeName(w.path, disk))
+	}
+}
+
+func writeSplit(dirPath, outPath string, messenger Messenger, opt Options) error {
+	writer, err := newVolumeWriter(outPath, opt.VolumeSize)
+	if err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(binary.LittleEndian.AppendUint32(nil, sigSplit)); err != nil {
+		writer.abort()
+		return err
+	}
+
+	zipWrite := zip.NewWriter(writer)
+	zipWrite.SetOffset(splitSignatureLen)
+
+	if err := addFS(zipWrite, os.DirFS(dirPath), messenger, opt); err != nil {
+		writer.abort()
+		return err
+	}
+
+	if err := zipWrite.Flush(); err != nil {
+		writer.abort()
+		return err
+	}
+
+	writer.capture = new(bytes.Buffer)
+
+	if err := zipWrite.Close(); err != nil {
+		writer.abort()
+		return err
+	}
+
+	if err := writer.finish(); err != nil {
+		writer.abort()
+		return err
+	}
+
+	return removeVolumes(outPath, writer.disk())
+}
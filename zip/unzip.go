@@ -21,73 +21,180 @@ package zip
 import (
 	"archive/zip"
 	"errors"
+	"fmt"
 	"io"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/ricochhet/minicommon/charmbracelet"
+	"github.com/ricochhet/minicommon/filesystem"
 )
 
+var ErrFileExists = errors.New("file exists in destination path")
+
 func DefaultUnzipMessenger() Messenger {
 	return Messenger{
 		AddedFile: func(path string) {
 			charmbracelet.SharedLogger.Infof("Unzipping file: %s", path)
 		},
+		Nested: func(nesting []string) {
+			charmbracelet.SharedLogger.Infof("Extracting nested archive: %s", strings.Join(nesting, " > "))
+		},
 	}
 }
 
-func Unzip(dirPath, outPath string) error {
-	return UnzipByPrefixWithMessenger(dirPath, outPath, "", DefaultUnzipMessenger())
+func Unzip(dirPath, outPath string, opts ...Options) error {
+	return UnzipByPrefixWithMessenger(dirPath, outPath, "", DefaultUnzipMessenger(), opts...)
 }
 
-func UnzipByPrefixWithMessenger(dirPath, outPath, extractPrefix string, messenger Messenger) error {
-	stepCopyBytes := 1024
-	zipRead, err := zip.OpenReader(dirPath)
-	if err != nil { //nolint:wsl // gofumpt conflict
+// UnzipByPrefixWithMessenger extracts the entries whose names start with
+// extractPrefix, without the prefix. A directory that cannot be created fails
+// the extraction.
+//
+//nolint:exhaustruct // wontfix
+func UnzipByPrefixWithMessenger(dirPath, outPath, extractPrefix string, messenger Messenger, opts ...Options) error {
+	filter := Filter{}
+
+	if extractPrefix != "" {
+		filter.Predicate = func(file *zip.File) bool {
+			return strings.HasPrefix(file.Name, extractPrefix)
+		}
+		filter.Remap = []Remap{{From: extractPrefix, To: ""}}
+	}
+
+	return UnzipWithFilter(dirPath, outPath, filter, messenger, opts...)
+}
+
+func UnzipWithFilter(dirPath, outPath string, filter Filter, messenger Messenger, opts ...Options) error {
+	zipRead, closer, err := openArchive(dirPath)
+	if err != nil {
 		return err
 	}
+	defer closer.Close()
+
+	return newExtractor(filter, messenger, assureOptions(opts...)).extract(zipRead, outPath, filter, 0, []string{dirPath})
+}
 
+func UnzipFromReader(zipRead *zip.Reader, outPath string, filter Filter, messenger Messenger, opts ...Options) error {
+	return newExtractor(filter, messenger, assureOptions(opts...)).extract(zipRead, outPath, filter, 0, nil)
+}
+
+func (e *extractor) extract(zipRead *zip.Reader, outPath string, filter Filter, depth int, nesting []string) error {
 	for _, file := range zipRead.File {
-		readclose, err := file.Open()
-		if err != nil {
-			return err
+		if !filter.match(file) {
+			continue
 		}
-		defer readclose.Close()
 
-		if extractPrefix != "" && !strings.HasPrefix(file.Name, extractPrefix) {
+		rewritten, ok := filter.rewrite(file.Name)
+		if !ok {
 			continue
 		}
 
-		name, err := url.QueryUnescape(maybeTrimPrefix(file.Name, extractPrefix))
+		name, err := url.QueryUnescape(rewritten)
 		if err != nil {
 			return err
 		}
 
-		destPath := filepath.Join(outPath, name)
-		messenger.AddedFile(destPath)
+		destPath, err := filesystem.SecureJoin(outPath, name)
+		if err != nil {
+			return err
+		}
 
 		if file.FileInfo().IsDir() {
+			e.messenger.AddedFile(destPath)
+
 			if err := os.MkdirAll(destPath, os.ModePerm); err != nil {
-				continue
+				return err
 			}
-		} else if err := stepCopy(destPath, readclose, int64(stepCopyBytes)); err != nil {
+
+			continue
+		}
+
+		write, err := shouldWrite(destPath, modTime(file), filter.Overwrite)
+		if err != nil {
+			return err
+		}
+
+		if !write {
+			continue
+		}
+
+		e.messenger.AddedFile(destPath)
+
+		if err := e.extractFile(file, destPath); err != nil {
 			return err
 		}
+
+		if depth < e.opt.NestedDepth {
+			if err := e.extractNested(destPath, depth+1, append(nesting[:len(nesting):len(nesting)], file.Name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (e *extractor) extractFile(file *zip.File, destPath string) error {
+	readclose, err := openEntry(file, e.opt.Password)
+	if err != nil {
+		return err
+	}
+	defer readclose.Close()
+
+	modified := modTime(file)
+	if file.Modified.IsZero() {
+		modified = time.Time{}
+	}
+
+	return e.writeFile(readclose, destPath, modified)
+}
+
+// writeFile writes src to destPath within the extraction's limits and, with
+// KeepModTimes, sets its modification time unless modified is zero.
+func (e *extractor) writeFile(src io.Reader, destPath string, modified time.Time) error {
+	stepCopyBytes := 1024
+
+	if err := e.addFile(); err != nil {
+		return err
 	}
 
-	zipRead.Close()
+	if err := stepCopy(destPath, &budgetReader{src: src, extractor: e}, int64(stepCopyBytes)); err != nil {
+		return err
+	}
+
+	if e.filter.KeepModTimes && !modified.IsZero() {
+		return os.Chtimes(destPath, modified, modified)
+	}
 
 	return nil
 }
 
-func maybeTrimPrefix(trimmable, prefix string) string {
-	if prefix != "" {
-		return strings.TrimPrefix(trimmable, prefix)
+func shouldWrite(destPath string, modified time.Time, policy OverwritePolicy) (bool, error) {
+	info, err := os.Stat(destPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	switch policy {
+	case OverwriteAlways:
+		return true, nil
+	case OverwriteNever:
+		return false, nil
+	case OverwriteIfNewer:
+		return modified.After(info.ModTime()), nil
+	case OverwriteFail:
+		return false, fmt.Errorf("%w: %s", ErrFileExists, destPath)
 	}
 
-	return trimmable
+	return true, nil
 }
 
 func stepCopy(dirPath string, outPath io.Reader, stepCopyBytes int64) error {
@@ -0,0 +1,283 @@
+/*
+ * minicommon
+ * Copyright (C) 2024 minicommon contributors
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package zip
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ricochhet/minicommon/filesystem"
+)
+
+var (
+	errEntryExists      = errors.New("entry already exists in archive")
+	errEntryNotFound    = errors.New("entry does not exist in archive")
+	errConflictingEntry = errors.New("entry is targeted by more than one operation")
+)
+
+type opKind int
+
+const (
+	opAdd opKind = iota
+	opReplace
+	opRename
+	opDelete
+)
+
+// Op is a single change applied to an archive by Update.
+type Op struct {
+	kind   opKind
+	name   string
+	target string
+	open   func() (io.ReadCloser, *zip.FileHeader, error)
+}
+
+// Add adds a new entry read from r, which must not exist yet.
+func Add(name string, r io.Reader) Op {
+	return Op{kind: opAdd, name: name, target: "", open: readerSource(name, r)}
+}
+
+// AddFile adds a new entry with the contents, mode and modification time of
+// the file at path.
+func AddFile(name, path string) Op {
+	return Op{kind: opAdd, name: name, target: "", open: fileSource(name, path)}
+}
+
+// Replace replaces the contents of an existing entry with r.
+func Replace(name string, r io.Reader) Op {
+	return Op{kind: opReplace, name: name, target: "", open: readerSource(name, r)}
+}
+
+// ReplaceFile replaces an existing entry with the file at path, as AddFile.
+func ReplaceFile(name, path string) Op {
+	return Op{kind: opReplace, name: name, target: "", open: fileSource(name, path)}
+}
+
+// Rename renames an existing entry to target without recompressing it.
+func Rename(name, target string) Op {
+	return Op{kind: opRename, name: name, target: target, open: nil}
+}
+
+// Delete removes an existing entry.
+func Delete(name string) Op {
+	return Op{kind: opDelete, name: name, target: "", open: nil}
+}
+
+// Update rewrites the archive at path with ops applied. Entries that are not
+// replaced are copied without recompression, and the result is written to a
+// temporary file next to path before being renamed over it, see
+// filesystem.AtomicWriter.
+func Update(path string, ops ...Op) error {
+	zipRead, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+
+	byName, err := planUpdate(zipRead.File, ops)
+	if err != nil {
+		zipRead.Close()
+		return err
+	}
+
+	w, err := filesystem.NewAtomicWriter(path, 0o644)
+	if err != nil {
+		zipRead.Close()
+		return err
+	}
+
+	err = writeUpdate(w, zipRead, byName, ops)
+
+	// Windows cannot rename over a file that is still open.
+	if closeErr := zipRead.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return errors.Join(err, w.Abort())
+	}
+
+	return w.Close()
+}
+
+func planUpdate(files []*zip.File, ops []Op) (map[string]Op, error) {
+	existing := make(map[string]bool, len(files))
+	for _, file := range files {
+		existing[file.Name] = true
+	}
+
+	byName := make(map[string]Op, len(ops))
+	targets := make(map[string]bool, len(ops))
+
+	for _, op := range ops {
+		if _, ok := byName[op.name]; ok {
+			return nil, fmt.Errorf("%w: %s", errConflictingEntry, op.name)
+		}
+
+		switch op.kind {
+		case opAdd:
+			if existing[op.name] {
+				return nil, fmt.Errorf("%w: %s", errEntryExists, op.name)
+			}
+		case opReplace, opDelete:
+			if !existing[op.name] {
+				return nil, fmt.Errorf("%w: %s", errEntryNotFound, op.name)
+			}
+		case opRename:
+			if !existing[op.name] {
+				return nil, fmt.Errorf("%w: %s", errEntryNotFound, op.name)
+			}
+
+			if targets[op.target] {
+				return nil, fmt.Errorf("%w: %s", errConflictingEntry, op.target)
+			}
+
+			targets[op.target] = true
+		}
+
+		byName[op.name] = op
+	}
+
+	for target := range targets {
+		if _, ok := byName[target]; ok {
+			return nil, fmt.Errorf("%w: %s", errConflictingEntry, target)
+		}
+
+		if existing[target] {
+			return nil, fmt.Errorf("%w: %s", errEntryExists, target)
+		}
+	}
+
+	return byName, nil
+}
+
+func writeUpdate(w io.Writer, zipRead *zip.ReadCloser, byName map[string]Op, ops []Op) error {
+	zipWrite := zip.NewWriter(w)
+
+	if err := zipWrite.SetComment(zipRead.Comment); err != nil {
+		return err
+	}
+
+	for _, file := range zipRead.File {
+		op, ok := byName[file.Name]
+
+		switch {
+		case !ok:
+			if err := zipWrite.Copy(file); err != nil {
+				return err
+			}
+		case op.kind == opDelete:
+			continue
+		case op.kind == opRename:
+			if err := copyRenamed(zipWrite, file, op.target); err != nil {
+				return err
+			}
+		default:
+			if err := writeOp(zipWrite, op); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, op := range ops {
+		if op.kind != opAdd {
+			continue
+		}
+
+		if err := writeOp(zipWrite, op); err != nil {
+			return err
+		}
+	}
+
+	return zipWrite.Close()
+}
+
+func copyRenamed(zipWrite *zip.Writer, file *zip.File, name string) error {
+	header := file.FileHeader
+	header.Name = name
+
+	raw, err := file.OpenRaw()
+	if err != nil {
+		return err
+	}
+
+	zipCreate, err := zipWrite.CreateRaw(&header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(zipCreate, raw)
+
+	return err
+}
+
+func writeOp(zipWrite *zip.Writer, op Op) error {
+	src, header, err := op.open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	zipCreate, err := zipWrite.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(zipCreate, src)
+
+	return err
+}
+
+func readerSource(name string, r io.Reader) func() (io.ReadCloser, *zip.FileHeader, error) {
+	return func() (io.ReadCloser, *zip.FileHeader, error) {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()} //nolint:exhaustruct // wontfix
+		header.SetMode(0o644)
+
+		return io.NopCloser(r), header, nil
+	}
+}
+
+func fileSource(name, path string) func() (io.ReadCloser, *zip.FileHeader, error) {
+	return func() (io.ReadCloser, *zip.FileHeader, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+
+		header.Name = name
+		header.Method = zip.Deflate
+
+		return file, header, nil
+	}
+}
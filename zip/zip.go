@@ -19,18 +19,15 @@
 package zip
 
 import (
-	"archive/zip"
-	"io"
-	"io/fs"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/ricochhet/minicommon/charmbracelet"
 )
 
 type Messenger struct {
 	AddedFile func(string)
+	Nested    func([]string)
 }
 
 func DefaultZipMessenger() Messenger {
@@ -38,74 +35,49 @@ func DefaultZipMessenger() Messenger {
 		AddedFile: func(path string) {
 			charmbracelet.SharedLogger.Infof("Adding file to zip: %s", path)
 		},
+		Nested: nil,
 	}
 }
 
-func Zip(dirPath string, outPath string) error {
-	return WithMessenger(dirPath, outPath, DefaultZipMessenger())
+func Zip(dirPath string, outPath string, opts ...Options) error {
+	return WithMessenger(dirPath, outPath, DefaultZipMessenger(), opts...)
 }
 
-func WithMessenger(dirPath string, outPath string, messenger Messenger) error {
+// WithMessenger archives the files below dirPath to outPath, split into
+// volumes when VolumeSize is set. When outPath ends in .zip, .z01, .z02 ...
+// volumes next to it that the new archive does not use are removed, as they
+// are left from an earlier split archive of the same name.
+func WithMessenger(dirPath string, outPath string, messenger Messenger, opts ...Options) error {
 	if err := os.MkdirAll(filepath.Dir(outPath), 0o700); err != nil {
 		return err
 	}
 
+	messenger = dirMessenger(dirPath, messenger)
+
+	if opt := assureOptions(opts...); opt.VolumeSize > 0 {
+		return writeSplit(dirPath, outPath, messenger, opt)
+	}
+
 	file, err := os.Create(outPath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	zipWrite := zip.NewWriter(file)
-	defer zipWrite.Close()
-
-	err = filepath.Walk(dirPath, func(path string, info fs.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-
-		if err != nil {
-			return err
-		}
-
-		zipCreate, err := zipWrite.Create(convertPath(path, dirPath))
-		if err != nil {
-			return err
-		}
-
-		messenger.AddedFile(path)
-
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-
-		defer file.Close()
-
-		if _, err = io.Copy(zipCreate, file); err != nil {
-			return err
-		}
-
-		return nil
-	})
-	if err != nil {
+	if err := ZipFSWithMessenger(os.DirFS(dirPath), file, messenger, opts...); err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func convertPath(path, src string) string {
-	path = trimSrcPrefix(path, src)
-	path = replaceBackslashes(path)
-
-	return path
+	return removeVolumes(outPath, 0)
 }
 
-func trimSrcPrefix(path, src string) string {
-	return strings.TrimPrefix(strings.TrimPrefix(path, src), string(filepath.Separator))
-}
-
-func replaceBackslashes(path string) string {
-	return strings.ReplaceAll(path, "\\", "/")
+// dirMessenger reports the slash-separated names of entries added from
+// dirPath as filesystem paths.
+func dirMessenger(dirPath string, messenger Messenger) Messenger {
+	return Messenger{
+		AddedFile: func(name string) {
+			messenger.AddedFile(filepath.Join(dirPath, filepath.FromSlash(name)))
+		},
+		Nested: messenger.Nested,
+	}
 }
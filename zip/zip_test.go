@@ -19,7 +19,19 @@
 package zip_test
 
 import (
+	stdzip "archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/ricochhet/minicommon/zip"
 )
@@ -35,3 +47,538 @@ func TestUnzip(t *testing.T) { //nolint:paralleltest // dependant
 		t.Fatal(err)
 	}
 }
+
+func TestZipFS(t *testing.T) {
+	t.Parallel()
+
+	src := fstest.MapFS{
+		"a.txt":     {Data: []byte("aaabbbccc")},
+		"dir/b.txt": {Data: []byte("dddeeefff")},
+	}
+
+	var buf bytes.Buffer
+	if err := zip.ZipFS(src, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := zip.OpenFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, file := range src {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, file.Data) {
+			t.Fatalf("unexpected content for %s", name)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "update.zip")
+	writeTestZip(t, path, fstest.MapFS{
+		"keep.txt":    {Data: []byte("keep")},
+		"replace.txt": {Data: []byte("old")},
+		"rename.txt":  {Data: []byte("rename")},
+		"delete.txt":  {Data: []byte("delete")},
+	})
+
+	err := zip.Update(path,
+		zip.Replace("replace.txt", strings.NewReader("new")),
+		zip.Rename("rename.txt", "renamed.txt"),
+		zip.Delete("delete.txt"),
+		zip.Add("added.txt", strings.NewReader("added")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := openTestZip(t, path)
+	want := map[string]string{"keep.txt": "keep", "replace.txt": "new", "renamed.txt": "rename", "added.txt": "added"}
+
+	for name, content := range want {
+		if data, err := fs.ReadFile(fsys, name); err != nil || string(data) != content {
+			t.Fatalf("unexpected content for %s: %q, %v", name, data, err)
+		}
+	}
+
+	for _, name := range []string{"rename.txt", "delete.txt"} {
+		if _, err := fs.Stat(fsys, name); err == nil {
+			t.Fatalf("%s still exists", name)
+		}
+	}
+
+	if err := zip.Update(path, zip.Add("keep.txt", strings.NewReader(""))); err == nil {
+		t.Fatal("adding an existing entry succeeded")
+	}
+}
+
+func writeTestZip(t *testing.T, path string, fsys fs.FS, opts ...zip.Options) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := zip.ZipFS(fsys, file, opts...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openTestZip(t *testing.T, path string) fs.FS {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := zip.OpenFS(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fsys
+}
+
+func TestListTestDiff(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "inspect.zip")
+	src := fstest.MapFS{
+		"a.txt":     {Data: []byte("aaabbbccc")},
+		"dir/b.txt": {Data: []byte("dddeeefff")},
+	}
+
+	writeTestZip(t, path, src)
+
+	entries, err := zip.List(path)
+	if err != nil || len(entries) != len(src) {
+		t.Fatalf("unexpected entries: %v, %v", entries, err)
+	}
+
+	if failed, err := zip.Test(path); err != nil || len(failed) != 0 {
+		t.Fatalf("unexpected test failures: %v, %v", failed, err)
+	}
+
+	tree := filepath.Join(dir, "tree")
+	if err := os.MkdirAll(filepath.Join(tree, "dir"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tree, "a.txt"), []byte("aaabbbccc"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tree, "dir", "b.txt"), []byte("changed"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := zip.Diff(path, tree, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diff) != 1 || diff[0].Hashes.File != "dir/b.txt" {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+
+	// AE-2 entries store no CRC, so the decrypted contents are compared.
+	encrypted := filepath.Join(dir, "encrypted.zip")
+	opts := zip.Options{Password: "secret", Encryption: zip.AES256}
+	writeTestZip(t, encrypted, src, opts)
+
+	for _, verifyHashes := range []bool{false, true} {
+		diff, err := zip.Diff(encrypted, path, verifyHashes, opts)
+		if err != nil || len(diff) != 0 {
+			t.Fatalf("unexpected encrypted diff: %+v, %v", diff, err)
+		}
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "encrypted.zip")
+	content := bytes.Repeat([]byte("aaabbbccc"), 64)
+
+	for _, method := range []uint16{stdzip.Store, stdzip.Deflate} {
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts := zip.Options{Method: method, Password: "secret", Encryption: zip.AES256}
+		if err := zip.ZipFS(fstest.MapFS{"a.txt": {Data: content}}, file, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		file.Close()
+
+		if err := zip.Unzip(path, filepath.Join(dir, "out"), opts); err != nil {
+			t.Fatal(err)
+		}
+
+		if data, err := os.ReadFile(filepath.Join(dir, "out", "a.txt")); err != nil || !bytes.Equal(data, content) {
+			t.Fatalf("unexpected content: %v", err)
+		}
+
+		if err := zip.Unzip(path, filepath.Join(dir, "out"), zip.Options{Method: method, Password: "wrong", Encryption: zip.AES256}); !errors.Is(err, zip.ErrWrongPassword) {
+			t.Fatalf("expected wrong password, got %v", err)
+		}
+
+		tamperEntry(t, path)
+
+		if failed, err := zip.Test(path, opts); err != nil || len(failed) != 1 || !errors.Is(failed[0], zip.ErrAuthentication) {
+			t.Fatalf("expected authentication failure, got %v, %v", failed, err)
+		}
+	}
+}
+
+func tamperEntry(t *testing.T, path string) {
+	t.Helper()
+
+	zipRead, err := stdzip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offset, err := zipRead.File[0].DataOffset()
+	zipRead.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[offset+20] ^= 0xff
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//nolint:exhaustruct // test only
+func TestUnzipWithFilter(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "filter.zip")
+	writeTestZip(t, path, fstest.MapFS{
+		"root/bin/app":        {Data: []byte("app")},
+		"root/bin/app.tmp":    {Data: []byte("tmp")},
+		"root/assets/a/b.png": {Data: []byte("png")},
+		"root/docs/readme.md": {Data: []byte("readme")},
+	})
+
+	out := filepath.Join(dir, "out")
+	filter := zip.Filter{
+		Include:         []string{"root/bin", "**/*.png"},
+		Exclude:         []string{"**/*.tmp"},
+		StripComponents: 1,
+		Remap:           []zip.Remap{{From: "assets/", To: "data/"}},
+		Overwrite:       zip.OverwriteFail,
+	}
+
+	if err := zip.UnzipWithFilter(path, out, filter, zip.Messenger{AddedFile: func(string) {}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"bin/app", "data/a/b.png"} {
+		if _, err := os.Stat(filepath.Join(out, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"bin/app.tmp", "docs/readme.md", "assets/a/b.png"} {
+		if _, err := os.Stat(filepath.Join(out, name)); err == nil {
+			t.Fatalf("%s was extracted", name)
+		}
+	}
+
+	if err := zip.UnzipWithFilter(path, out, filter, zip.Messenger{AddedFile: func(string) {}}); !errors.Is(err, zip.ErrFileExists) {
+		t.Fatalf("expected ErrFileExists, got %v", err)
+	}
+
+	filter.Overwrite = zip.OverwriteNever
+	if err := zip.UnzipWithFilter(path, out, filter, zip.Messenger{AddedFile: func(string) {}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := zip.Update(path, zip.Add("../bad", strings.NewReader("bad"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := zip.UnzipWithFilter(path, out, zip.Filter{}, zip.Messenger{AddedFile: func(string) {}}); err == nil {
+		t.Fatal("unsafe path was extracted")
+	}
+}
+
+//nolint:exhaustruct // test only
+func TestKeepModTimes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "times.zip")
+	exact := time.Date(2020, 1, 2, 3, 4, 5, 123456700, time.UTC)
+
+	// An NTFS timestamp, as written by 7-Zip and Windows, holds the exact
+	// time in 100ns intervals since 1601.
+	ntfs := make([]byte, 36)
+	binary.LittleEndian.PutUint16(ntfs[0:], 0x000a)
+	binary.LittleEndian.PutUint16(ntfs[2:], 32)
+	binary.LittleEndian.PutUint16(ntfs[8:], 1)
+	binary.LittleEndian.PutUint16(ntfs[10:], 24)
+
+	for i := range 3 {
+		binary.LittleEndian.PutUint64(ntfs[12+8*i:], uint64(exact.UnixNano()/100+116444736000000000)) //nolint:gosec // positive
+	}
+
+	// A bare MS-DOS time of 2020-01-02 03:04:04 in no particular zone.
+	dosTime, dosDate := uint16(3<<11|4<<5|2), uint16(40<<9|1<<5|2)
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zipWrite := stdzip.NewWriter(file)
+
+	for _, header := range []*stdzip.FileHeader{
+		{Name: "ntfs.txt", ModifiedTime: dosTime, ModifiedDate: dosDate, Extra: ntfs},
+		{Name: "dos.txt", ModifiedTime: dosTime, ModifiedDate: dosDate},
+	} {
+		if _, err := zipWrite.CreateHeader(header); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := errors.Join(zipWrite.Close(), file.Close()); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := zip.UnzipWithFilter(path, out, zip.Filter{KeepModTimes: true}, zip.Messenger{AddedFile: func(string) {}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]time.Time{
+		"ntfs.txt": exact,
+		"dos.txt":  time.Date(2020, 1, 2, 3, 4, 4, 0, time.Local),
+	} {
+		if info, err := os.Stat(filepath.Join(out, name)); err != nil || !info.ModTime().Equal(want) {
+			t.Fatalf("unexpected time of %s: %v, %v", name, info, err)
+		}
+	}
+}
+
+//nolint:exhaustruct // test only
+func TestSFX(t *testing.T) {
+	t.Parallel()
+
+	stub, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	out := filepath.Join(dir, "installer")
+
+	if err := os.MkdirAll(src, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(src, "payload.txt"), []byte("payload"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := zip.BuildSFXWithMessenger(stub, src, out, zip.Messenger{AddedFile: func(string) {}}); err != nil {
+		t.Fatal(err)
+	}
+
+	sfx, err := zip.OpenSFX(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sfx.Close()
+
+	if sfx.Offset != info.Size() {
+		t.Fatalf("unexpected overlay offset %d, expected %d", sfx.Offset, info.Size())
+	}
+
+	if data, err := fs.ReadFile(sfx, "payload.txt"); err != nil || string(data) != "payload" {
+		t.Fatalf("unexpected payload: %q, %v", data, err)
+	}
+}
+
+//nolint:exhaustruct // test only
+func TestUnzipNested(t *testing.T) {
+	t.Parallel()
+
+	var inner bytes.Buffer
+	if err := zip.ZipFS(fstest.MapFS{"deep.txt": {Data: []byte("deep")}}, &inner); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "outer.zip")
+	writeTestZip(t, path, fstest.MapFS{
+		"top.txt":         {Data: []byte("top")},
+		"nest/inner.zip":  {Data: inner.Bytes()},
+		"nest/bundle.pak": {Data: inner.Bytes()},
+	})
+
+	var nesting []string
+
+	messenger := zip.Messenger{
+		AddedFile: func(string) {},
+		Nested:    func(n []string) { nesting = n },
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := zip.UnzipWithFilter(path, out, zip.Filter{}, messenger, zip.Options{NestedDepth: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(out, "nest", "inner", "deep.txt")); err != nil || string(data) != "deep" {
+		t.Fatalf("nested archive was not extracted: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(out, "nest", "inner.zip")); err == nil {
+		t.Fatal("nested archive was kept")
+	}
+
+	if _, err := os.Stat(filepath.Join(out, "nest", "bundle", "deep.txt")); err != nil {
+		t.Fatalf("nested archive was not found by its signature: %v", err)
+	}
+
+	if len(nesting) != 2 || (nesting[1] != "nest/inner.zip" && nesting[1] != "nest/bundle.pak") {
+		t.Fatalf("unexpected nesting path: %v", nesting)
+	}
+
+	only := filepath.Join(dir, "only")
+	opt := zip.Options{NestedDepth: 1, NestedExtensions: []string{".zip"}}
+
+	if err := zip.UnzipWithFilter(path, only, zip.Filter{}, messenger, opt); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(only, "nest", "bundle.pak")); err != nil {
+		t.Fatalf("an archive without an allowed extension was opened: %v", err)
+	}
+
+	err := zip.UnzipWithFilter(path, filepath.Join(dir, "limited"), zip.Filter{}, messenger, zip.Options{NestedDepth: 1, MaxFiles: 2})
+	if !errors.Is(err, zip.ErrLimitExceeded) {
+		t.Fatalf("expected limit error, got %v", err)
+	}
+}
+
+//nolint:exhaustruct // test only
+func TestSplit(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+
+	if err := os.MkdirAll(src, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]byte{}
+
+	for i, size := range []int{3000, 10, 9000, 1} {
+		name := fmt.Sprintf("file%d.bin", i)
+		want[name] = make([]byte, size)
+
+		if _, err := rand.Read(want[name]); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(src, name), want[name], 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messenger := zip.Messenger{AddedFile: func(string) {}}
+	path := filepath.Join(dir, "split.zip")
+
+	if err := zip.WithMessenger(src, path, messenger, zip.Options{Method: stdzip.Store, VolumeSize: 4096}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "split.z02")); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := zip.UnzipWithFilter(path, out, zip.Filter{}, messenger); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range want {
+		if data, err := os.ReadFile(filepath.Join(out, name)); err != nil || !bytes.Equal(data, content) {
+			t.Fatalf("unexpected content for %s: %v", name, err)
+		}
+	}
+
+	volumes, err := filepath.Glob(filepath.Join(dir, "split.z*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, volume := range volumes {
+		if info, err := os.Stat(volume); err != nil || info.Size() > 4096 {
+			t.Fatalf("%s exceeds the volume size: %v", volume, err)
+		}
+	}
+
+	// Writing the archive again, split into fewer volumes or not at all,
+	// removes the volumes the new archive does not use.
+	for _, size := range []int64{8192, 0} {
+		if err := zip.WithMessenger(src, path, messenger, zip.Options{Method: stdzip.Store, VolumeSize: size}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := zip.UnzipWithFilter(path, filepath.Join(dir, fmt.Sprint("out", size)), zip.Filter{}, messenger); err != nil {
+			t.Fatalf("volume size %d: %v", size, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "split.z01")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("stale volumes were kept: %v", err)
+	}
+
+	// Only archives named .zip own the volumes next to them.
+	unrelated := filepath.Join(dir, "data.z01")
+	if err := os.WriteFile(unrelated, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := zip.WithMessenger(src, filepath.Join(dir, "data.bin"), messenger); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Fatalf("an unrelated file was removed: %v", err)
+	}
+}